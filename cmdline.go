@@ -0,0 +1,202 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ParseString parses a whole command line, which should not contain the
+// program name, using a default FlagMaker.
+//
+// See (*FlagMaker).ParseString for the quoting rules.
+func ParseString(obj interface{}, cmdline string) error {
+	return NewFlagMaker().ParseString(obj, cmdline)
+}
+
+// ParseString splits the command line into arguments with shell-like quoting
+// and parses them. Arguments are separated by white spaces, unless they're
+// quoted:
+//
+//   - inside single quotes, every character is taken literally.
+//   - inside double quotes, a backslash only escapes '"' and '\'.
+//   - outside quotes, a backslash escapes any character.
+//
+// Unterminated quotes and trailing backslashes are errors. No other shell
+// expansion (variables, globs etc.) is performed. It's an error if arguments
+// are left after the flags.
+func (fm *FlagMaker) ParseString(obj interface{}, cmdline string) error {
+	args, err := splitCommandLine(cmdline)
+	if err != nil {
+		return err
+	}
+	left, err := fm.ParseArgs(obj, args)
+	if err != nil {
+		return err
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("unexpected arguments %q", left)
+	}
+	return nil
+}
+
+// secretMask stands for the values of the fields with the secret option in the
+// output of ToArgs and ToEnviron.
+const secretMask = "****"
+
+// ToArgs renders the current values of the fields of obj as arguments, e.g.
+// --network.tcp.readtimeout=10ms, sorted by flag name. It's the inverse of
+// ParseArgs: multi-value flags are repeated for each element, and nil pointers
+// are omitted. The values of the fields with the secret option are rendered
+// as **** unless the RevealSecrets option is set. nil is returned if obj
+// cannot have flags defined for.
+func (fm *FlagMaker) ToArgs(obj interface{}) []string {
+	r, err := fm.inspect(obj)
+	if err != nil {
+		return nil
+	}
+
+	var args []string
+	r.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := r.fields[f.Name]; !ok {
+			// e.g. composite flags and alternative names
+			return
+		}
+		if l, ok := f.Value.(*lazyValue); ok && l.isNil() {
+			return
+		}
+		vals := []string{r.redact(f.Value.String())}
+		if mv, ok := baseValue(f.Value).(multiValue); ok {
+			vals, _ = r.redactValues(mv.values())
+			for _, val := range vals {
+				if len(val) == 0 {
+					// clear the field first, so that the empty elements
+					// are added rather than clearing it
+					vals = append([]string{""}, vals...)
+					break
+				}
+			}
+		}
+		if r.masked(f.Name) {
+			vals = []string{secretMask}
+		}
+		for _, val := range vals {
+			args = append(args, "--"+f.Name+"="+val)
+		}
+	})
+	return args
+}
+
+// masked tells whether the value of the flag name is masked in the output of
+// ToArgs and ToEnviron.
+func (fm *FlagMaker) masked(name string) bool {
+	return fm.fields[name].opts.has("secret") && !fm.opts.RevealSecrets
+}
+
+// RedactMatching masks the values matching re in the output of Describe,
+// ToArgs and ToEnviron, e.g. tokens held by fields without the secret option.
+// It only affects what's rendered, not parsing.
+func (fm *FlagMaker) RedactMatching(re *regexp.Regexp) {
+	fm.redactors = append(fm.redactors, re)
+}
+
+// redact returns the mask for val if it matches an expression registered with
+// RedactMatching, and val otherwise.
+func (fm *FlagMaker) redact(val string) string {
+	for _, re := range fm.redactors {
+		if re.MatchString(val) {
+			return secretMask
+		}
+	}
+	return val
+}
+
+// redactValues returns vals with the values matching an expression
+// registered with RedactMatching masked, and whether any of them was.
+func (fm *FlagMaker) redactValues(vals []string) ([]string, bool) {
+	var redacted []string
+	for i, val := range vals {
+		if masked := fm.redact(val); masked != val {
+			if redacted == nil {
+				redacted = append([]string(nil), vals...)
+			}
+			redacted[i] = masked
+		}
+	}
+	if redacted == nil {
+		return vals, false
+	}
+	return redacted, true
+}
+
+func splitCommandLine(cmdline string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range cmdline {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				cur.WriteRune('\\')
+			}
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in command line")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command line", quote)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	cases := []struct {
+		cmdline  string
+		expected []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"--a b", []string{"--a", "b"}},
+		{"  --a \t b  ", []string{"--a", "b"}},
+		{`--name "a b"`, []string{"--name", "a b"}},
+		{`--name 'c d'`, []string{"--name", "c d"}},
+		{`--name=x"y z"w`, []string{"--name=xy zw"}},
+		{`--name ""`, []string{"--name", ""}},
+		{`--name 'it''s'`, []string{"--name", "its"}},
+		{`--name "say \"hi\""`, []string{"--name", `say "hi"`}},
+		{`--name "a\b"`, []string{"--name", `a\b`}},
+		{`--name 'a\b'`, []string{"--name", `a\b`}},
+		{`--name a\ b`, []string{"--name", "a b"}},
+	}
+	for _, c := range cases {
+		args, err := splitCommandLine(c.cmdline)
+		assert.Nil(t, err, c.cmdline)
+		assert.Equal(t, c.expected, args, c.cmdline)
+	}
+
+	for _, cmdline := range []string{`--name "a b`, `--name 'a`, `--name a\`} {
+		_, err := splitCommandLine(cmdline)
+		assert.Error(t, err, cmdline)
+	}
+}
+
+func TestParseString(t *testing.T) {
+	type C struct {
+		Name  string
+		Path  string
+		Hosts []string
+		Level int
+	}
+	c := &C{}
+	err := ParseString(c, `--name "my service" -path='/var/log/my app' --hosts "h 1" --hosts h2 --level 3`)
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Name: "my service", Path: "/var/log/my app", Hosts: []string{"h 1", "h2"}, Level: 3}, c)
+
+	err = ParseString(&C{}, `--name "unterminated`)
+	assert.Error(t, err)
+
+	err = ParseString(&C{}, `--level x`)
+	assert.Error(t, err)
+
+	err = ParseString(&C{}, `--name a extra`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected arguments")
+}
+
+func TestToArgs(t *testing.T) {
+	type C struct {
+		Name     string
+		Password string   `flag:",secret"`
+		Tokens   []string `flag:",secret"`
+		Hosts    []string
+		Retries  *int
+	}
+	c := &C{Name: "svc", Password: "hunter2", Tokens: []string{"t1", "t2"}, Hosts: []string{"a", "b"}}
+	args := NewFlagMaker().ToArgs(c)
+	assert.Equal(t, []string{"--hosts=a", "--hosts=b", "--name=svc", "--password=****", "--tokens=****"}, args)
+
+	env := NewFlagMaker().ToEnviron(c, "app")
+	assert.Contains(t, env, "APP_PASSWORD=****")
+	assert.Contains(t, env, "APP_NAME=svc")
+
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, RevealSecrets: true})
+	args = fm.ToArgs(c)
+	assert.Equal(t, []string{"--hosts=a", "--hosts=b", "--name=svc", "--password=hunter2", "--tokens=t1", "--tokens=t2"}, args)
+
+	parsed := &C{}
+	_, err := NewFlagMaker().ParseArgs(parsed, args)
+	assert.Nil(t, err)
+	assert.Equal(t, c, parsed)
+
+	infos, err := NewFlagMaker().Describe(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "****", infos[2].Default)
+	assert.Equal(t, "****", infos[4].Default)
+	out, err := NewFlagMaker().DescribeJSON(c)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(out), "hunter2")
+
+	fm = NewFlagMaker()
+	_, err = fm.ParseArgs(c, nil)
+	assert.Nil(t, err)
+	fm.Freeze(c)
+	c.Password = "hunter3"
+	err = fm.VerifyUnchanged(c)
+	assert.Error(t, err)
+	assert.Equal(t, "field of flag password changed from **** to ****", err.Error())
+}
+
+func TestToArgsEmptyElements(t *testing.T) {
+	type C struct {
+		Hosts []string
+	}
+	c := &C{Hosts: []string{"a", "", "b", ""}}
+	args := NewFlagMaker().ToArgs(c)
+	assert.Equal(t, []string{"--hosts=", "--hosts=a", "--hosts=", "--hosts=b", "--hosts="}, args)
+
+	parsed := &C{Hosts: []string{"x"}}
+	_, err := NewFlagMaker().ParseArgs(parsed, args)
+	assert.Nil(t, err)
+	assert.Equal(t, c, parsed)
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// CompiledBinder parses arguments into objects of a single type without
+// walking them: the flags are defined once by Compile, on an object owned by
+// the binder, and the location of the field of each flag is precomputed, so
+// that parsing only copies the fields backed by flags in and out of the
+// object parsed.
+type CompiledBinder struct {
+	fm         *FlagMaker
+	typ        reflect.Type
+	template   interface{}
+	fields     []boundField
+	validators []func(obj interface{}) error
+	locker     sync.Locker
+}
+
+// boundField is a field a flag is defined for, as located in the object
+// compiled and in the objects parsed.
+type boundField struct {
+	name  string
+	steps []step
+	// The field in the object owned by the binder.
+	value reflect.Value
+	// The value of the flag if it's defined on a detached value, for a nil
+	// pointer field.
+	lazy *lazyValue
+}
+
+// step leads from a value to one of its fields, to the value it points to, or
+// to one of its elements.
+type step struct {
+	kind stepKind
+	// The index of the field or of the element.
+	index int
+}
+
+type stepKind int
+
+const (
+	fieldStep stepKind = iota
+	elemStep
+	indexStep
+)
+
+// fieldKey identifies a field by its address and type, since a struct shares
+// its address with its first field.
+type fieldKey struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+// Compile defines the flags for obj, with the options and registrations of
+// the FlagMaker, and returns a binder parsing arguments into objects of the
+// type of obj, e.g. on every reload, without defining the flags again. The
+// flags are the ones the object would have had: slices of structs have the
+// elements of obj, and the objects parsed must have at least as many. obj
+// itself is left untouched.
+func (fm *FlagMaker) Compile(obj interface{}) (*CompiledBinder, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("cannot compile nil")
+	}
+	if _, ok := mapTarget(obj); ok {
+		return nil, fmt.Errorf("cannot compile map targets")
+	}
+	b := &CompiledBinder{
+		fm:       fm.like(),
+		typ:      reflect.TypeOf(obj),
+		template: deepCopy(reflect.ValueOf(obj)).Interface(),
+	}
+	// Parse locks the Locker itself, for the whole parse
+	opts := *fm.opts
+	opts.Locker = nil
+	b.fm.opts = &opts
+	// validators are called with the object parsed rather than the template
+	b.validators, b.fm.validators = b.fm.validators, nil
+	b.locker = fm.opts.Locker
+	if err := b.fm.defineFlags(b.template); err != nil {
+		return nil, err
+	}
+	if len(b.fm.catchalls) > 0 {
+		return nil, fmt.Errorf("cannot compile catchall fields")
+	}
+	if len(b.fm.instances) > 0 {
+		// they would set the fields of the template
+		return nil, fmt.Errorf("cannot compile objects with finalizers")
+	}
+
+	located := make(map[fieldKey][]step)
+	locate(reflect.ValueOf(b.template), nil, located)
+	var err error
+	b.fm.fs.VisitAll(func(f *flag.Flag) {
+		field, ok := b.fm.fields[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		bound := boundField{name: f.Name, value: field.value}
+		if l, ok := f.Value.(*lazyValue); ok {
+			bound.value, bound.lazy = l.field, l
+		}
+		steps, ok := located[fieldKey{addr: bound.value.UnsafeAddr(), typ: bound.value.Type()}]
+		if !ok {
+			err = fmt.Errorf("cannot compile flag %s", f.Name)
+			return
+		}
+		bound.steps = steps
+		b.fields = append(b.fields, bound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// locate records the steps leading from the root to each field reachable
+// from v, which is reached through steps.
+func locate(v reflect.Value, steps []step, located map[fieldKey][]step) {
+	if v.CanAddr() {
+		located[fieldKey{addr: v.UnsafeAddr(), typ: v.Type()}] = steps
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			locate(v.Elem(), append(steps[:len(steps):len(steps)], step{kind: elemStep}), located)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			locate(v.Field(i), append(steps[:len(steps):len(steps)], step{kind: fieldStep, index: i}), located)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct || v.Type().Elem().Kind() == reflect.Ptr {
+			for i := 0; i < v.Len(); i++ {
+				locate(v.Index(i), append(steps[:len(steps):len(steps)], step{kind: indexStep, index: i}), located)
+			}
+		}
+	}
+}
+
+// follow returns the value steps lead to from v, allocating the nil pointers
+// on the way.
+func follow(v reflect.Value, steps []step) (reflect.Value, error) {
+	for _, s := range steps {
+		switch s.kind {
+		case fieldStep:
+			v = v.Field(s.index)
+		case elemStep:
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		case indexStep:
+			if s.index >= v.Len() {
+				return v, fmt.Errorf("%v is shorter than the compiled one", v.Type())
+			}
+			v = v.Index(s.index)
+		}
+	}
+	return v, nil
+}
+
+// Parse is like ParseArgs for obj, which must be of the type of the object
+// compiled. obj is left unchanged if parsing fails. Parse must not be called
+// concurrently.
+func (b *CompiledBinder) Parse(obj interface{}, args []string) ([]string, error) {
+	if t := reflect.TypeOf(obj); t != b.typ {
+		return args, fmt.Errorf("binder compiled for %v cannot parse into %v", b.typ, t)
+	}
+	root := reflect.ValueOf(obj)
+	if root.IsNil() {
+		return args, fmt.Errorf("top level object cannot be nil")
+	}
+	if l := b.locker; l != nil {
+		l.Lock()
+		defer l.Unlock()
+	}
+	dsts := make([]reflect.Value, len(b.fields))
+	saved := make([]reflect.Value, len(b.fields))
+	for i, f := range b.fields {
+		dst, err := follow(root, f.steps)
+		if err != nil {
+			return args, fmt.Errorf("flag %s: %v", f.name, err)
+		}
+		dsts[i] = dst
+		saved[i] = reflect.New(dst.Type()).Elem()
+		saved[i].Set(dst)
+		f.copyIn(dst)
+	}
+
+	left, err := b.fm.ParseArgs(b.template, args)
+	if err != nil {
+		return left, err
+	}
+	for i, f := range b.fields {
+		f.copyOut(dsts[i])
+	}
+	for _, validate := range b.validators {
+		if err := validate(obj); err != nil {
+			for i, dst := range dsts {
+				dst.Set(saved[i])
+			}
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+// copyIn sets the field of the binder to a copy of the field src of the
+// object parsed.
+func (f boundField) copyIn(src reflect.Value) {
+	if f.lazy == nil {
+		f.value.Set(copyValue(src))
+		return
+	}
+	if src.IsNil() {
+		f.lazy.detach()
+		return
+	}
+	f.lazy.target.Elem().Set(copyValue(src.Elem()))
+	f.lazy.attach()
+}
+
+// copyOut sets the field dst of the object parsed to the field of the binder.
+// The values pointed to by optional fields are copied, since the binder sets
+// them in place.
+func (f boundField) copyOut(dst reflect.Value) {
+	if f.lazy == nil || f.value.IsNil() {
+		dst.Set(f.value)
+		return
+	}
+	p := reflect.New(f.value.Type().Elem())
+	p.Elem().Set(f.value.Elem())
+	dst.Set(p)
+}
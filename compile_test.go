@@ -0,0 +1,137 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagMakerCompile(t *testing.T) {
+	type tls struct {
+		Cert string
+	}
+	type worker struct {
+		Name string
+	}
+	type C struct {
+		Name    string
+		Timeout time.Duration
+		Hosts   []string
+		TLS     *tls
+		Limit   *int
+		Workers []worker
+		note    string
+	}
+	fm := NewFlagMaker()
+	fm.AddCrossValidator(func(obj interface{}) error {
+		if obj.(*C).Name == "invalid" {
+			return fmt.Errorf("invalid name")
+		}
+		return nil
+	})
+	proto := &C{Workers: make([]worker, 2)}
+	b, err := fm.Compile(proto)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		c := &C{Name: "db", Hosts: []string{"a"}, note: "kept", Workers: make([]worker, 2)}
+		args, err := b.Parse(c, []string{"--name", fmt.Sprint("cache", i), "--hosts", "b", "--tls.cert", "x.pem", "--limit", "3", "--workers.1.name", "w", "rest"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"rest"}, args)
+		limit := 3
+		assert.Equal(t, &C{
+			Name:    fmt.Sprint("cache", i),
+			Hosts:   []string{"b"},
+			TLS:     &tls{Cert: "x.pem"},
+			Limit:   &limit,
+			Workers: []worker{{}, {Name: "w"}},
+			note:    "kept",
+		}, c)
+	}
+	assert.Equal(t, &C{Workers: make([]worker, 2)}, proto)
+
+	// fields which aren't given keep their values, and as with ParseArgs nil
+	// pointers to structs are allocated
+	c := &C{Timeout: time.Second, Workers: make([]worker, 2)}
+	_, err = b.Parse(c, []string{"--name", "queue"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Name: "queue", Timeout: time.Second, TLS: &tls{}, Workers: make([]worker, 2)}, c)
+
+	_, err = b.Parse(c, []string{"--name", "invalid"})
+	assert.EqualError(t, err, "invalid name")
+	assert.Equal(t, "queue", c.Name)
+	_, err = b.Parse(c, []string{"--timeout", "x"})
+	assert.Error(t, err)
+	assert.Equal(t, time.Second, c.Timeout)
+
+	_, err = b.Parse(&C{}, nil)
+	assert.EqualError(t, err, "flag workers.0.name: []flags.worker is shorter than the compiled one")
+	_, err = b.Parse(&worker{}, nil)
+	assert.Error(t, err)
+}
+
+// benchConfig is a large struct, as configs of services get.
+type benchConfig struct {
+	Name    string
+	Hosts   []string
+	Servers [16]struct{ Placeholder int }
+	Network struct {
+		TCP, UDP, HTTP, GRPC struct {
+			Addr         string
+			ReadTimeout  time.Duration
+			WriteTimeout time.Duration
+			MaxConns     int
+			KeepAlive    bool
+			Tags         []string
+		}
+	}
+	Logging struct {
+		Level, Path, Format string
+		Sampling            float64
+	}
+}
+
+var benchArgs = []string{"--name", "svc", "--network.tcp.addr", ":80", "--network.grpc.maxconns", "100", "--logging.level", "debug"}
+
+func BenchmarkParseArgs(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := NewFlagMaker().ParseArgs(&benchConfig{}, benchArgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledBinder(b *testing.B) {
+	binder, err := NewFlagMaker().Compile(&benchConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := binder.Parse(&benchConfig{}, benchArgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlagInfo describes a flag created for a field.
+type FlagInfo struct {
+	// Name of the flag, e.g. network.tcp.readtimeout.
+	Name string
+	// Type of the field value, e.g. time.Duration or []string.
+	Type string
+	// Usage message of the flag.
+	Usage string
+	// Default is the value of the field when the flag was created, as it
+	// would be shown by PrintDefaults.
+	Default string
+	// Deprecated is set if the flag is deprecated, to the deprecation message
+	// if any, or to "deprecated" otherwise.
+	Deprecated string `json:",omitempty"`
+	// Group of the flag given by the group struct tag of the field, or of
+	// the closest struct containing it, if any.
+	Group string `json:",omitempty"`
+}
+
+// DefaultGroup is the section of DescribeJSON holding the flags without a group.
+const DefaultGroup = "default"
+
+// Describe returns the flags which would be created for obj, sorted by name,
+// without parsing anything. The flags with the visibleif option are only
+// returned if their condition holds. As with ParseArgs, the flags of the
+// fields of nil pointers to structs are described as well, though obj is
+// left untouched.
+func (fm *FlagMaker) Describe(obj interface{}) ([]FlagInfo, error) {
+	_, infos, err := fm.describe(obj)
+	return infos, err
+}
+
+// describe returns the flags Describe returns for obj, along with the
+// FlagMaker they were defined on.
+func (fm *FlagMaker) describe(obj interface{}) (*FlagMaker, []FlagInfo, error) {
+	r, err := fm.inspect(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var infos []FlagInfo
+	r.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := r.alternates[f.Name]; ok || err != nil {
+			return
+		}
+		if r.fields[f.Name].opts.has("visibleif") {
+			_, _, visible, cerr := r.condition(f.Name, "visibleif")
+			if cerr != nil || !visible {
+				err = cerr
+				return
+			}
+		}
+		info := FlagInfo{
+			Name:    f.Name,
+			Usage:   f.Usage,
+			Default: f.DefValue,
+		}
+		info.Group = r.fields[f.Name].opts.get("group", "")
+		if opts := r.fields[f.Name].opts; opts.has("deprecated") {
+			info.Deprecated = opts.get("deprecated", "")
+			if len(info.Deprecated) == 0 {
+				info.Deprecated = "deprecated"
+			}
+		}
+		if g, ok := f.Value.(flag.Getter); ok {
+			info.Type = fmt.Sprintf("%T", g.Get())
+			if d, ok := g.Get().(time.Duration); ok && fm.opts.ShortDurations {
+				info.Default = shortDuration(d)
+			}
+		}
+		if mv, ok := baseValue(f.Value).(multiValue); ok {
+			if vals, redacted := r.redactValues(mv.values()); redacted {
+				info.Default = "[" + strings.Join(vals, " ") + "]"
+			}
+		} else {
+			info.Default = r.redact(info.Default)
+		}
+		if r.masked(f.Name) {
+			info.Default = secretMask
+		}
+		infos = append(infos, info)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, infos, nil
+}
+
+// Repeatable tells for each flag Describe would return for obj whether it can
+// be given more than once, accumulating its values, e.g. for slices, maps and
+// the count option, or whether the last value wins. nil is returned if obj
+// cannot have flags defined for.
+func (fm *FlagMaker) Repeatable(obj interface{}) map[string]bool {
+	r, infos, err := fm.describe(obj)
+	if err != nil {
+		return nil
+	}
+	repeatable := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		repeatable[info.Name] = r.repeatable(info.Name)
+	}
+	return repeatable
+}
+
+// DescribeJSON returns the flags Describe returns for obj as a JSON object
+// mapping the groups to the flags in the group, sorted by name. The flags
+// without a group are in DefaultGroup.
+func (fm *FlagMaker) DescribeJSON(obj interface{}) ([]byte, error) {
+	infos, err := fm.Describe(obj)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]FlagInfo)
+	for _, info := range infos {
+		group := info.Group
+		if len(group) == 0 {
+			group = DefaultGroup
+		}
+		groups[group] = append(groups[group], info)
+	}
+	return json.MarshalIndent(groups, "", "  ")
+}
+
+// SchemaField describes a flag in the output of Schema: the FlagInfo of the
+// flag, and the constraints given by the options of its field, so that e.g. a
+// form can be rendered to edit the field.
+type SchemaField struct {
+	FlagInfo
+	// Multiple is set if the flag can be repeated, e.g. for a slice.
+	Multiple bool `json:",omitempty"`
+	// Required is set by the required option.
+	Required bool `json:",omitempty"`
+	// RequiredIf is the condition of the requiredif option, e.g. mode=tls.
+	RequiredIf string `json:",omitempty"`
+	// OneOf are the values allowed by the oneof option.
+	OneOf []string `json:",omitempty"`
+	// MaxBytes is the limit of the maxbytes option.
+	MaxBytes int `json:",omitempty"`
+	// MinDuration is the floor of the mindur option, e.g. 1s, and StrictMin
+	// whether the durations below are rejected rather than raised.
+	MinDuration string `json:",omitempty"`
+	StrictMin   bool   `json:",omitempty"`
+}
+
+// Schema returns the flags Describe returns for obj, along with the
+// constraints of their fields, as a JSON array of SchemaField. The values
+// submitted for the flags, e.g. through a form, can be turned back into
+// arguments for ParseArgs with SchemaArgs.
+func (fm *FlagMaker) Schema(obj interface{}) ([]byte, error) {
+	r, infos, err := fm.describe(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]SchemaField, 0, len(infos))
+	for _, info := range infos {
+		f := r.fs.Lookup(info.Name)
+		opts := r.fields[info.Name].opts
+		field := SchemaField{
+			FlagInfo:    info,
+			Required:    opts.has("required"),
+			RequiredIf:  opts.get("requiredif", ""),
+			OneOf:       strings.Fields(opts.get("oneof", "")),
+			MinDuration: opts.get("mindur", ""),
+			StrictMin:   opts.has("strictmin"),
+		}
+		_, field.Multiple = baseValue(f.Value).(multiValue)
+		if limit, err := strconv.Atoi(opts.get("maxbytes", "")); err == nil {
+			field.MaxBytes = limit
+		}
+		fields = append(fields, field)
+	}
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// SchemaArgs turns the values submitted for the flags of obj, by flag name,
+// e.g. through a form rendered from Schema, into arguments for ParseArgs.
+// The arguments are sorted by flag name, and the flags taking several values
+// are repeated. It's an error if a name isn't a flag of obj, or if several
+// values are given for a flag taking a single one.
+func (fm *FlagMaker) SchemaArgs(obj interface{}, values map[string][]string) ([]string, error) {
+	r, err := fm.inspect(obj)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		f := r.fs.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("flag %s is not defined", name)
+		}
+		if _, ok := baseValue(f.Value).(multiValue); !ok && len(values[name]) > 1 {
+			return nil, fmt.Errorf("flag %s takes a single value, got %d", name, len(values[name]))
+		}
+		for _, v := range values[name] {
+			args = append(args, "--"+name+"="+v)
+		}
+	}
+	return args, nil
+}
+
+// durationUnits are the units used by shortDuration, largest first.
+var durationUnits = []struct {
+	d    time.Duration
+	name string
+}{
+	{time.Hour, "h"},
+	{time.Minute, "m"},
+	{time.Second, "s"},
+	{time.Millisecond, "ms"},
+	{time.Microsecond, "us"},
+}
+
+// shortDuration formats d in the largest unit it's a whole number of.
+func shortDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	for _, u := range durationUnits {
+		if d%u.d == 0 {
+			return fmt.Sprintf("%d%s", d/u.d, u.name)
+		}
+	}
+	return fmt.Sprintf("%dns", d)
+}
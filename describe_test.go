@@ -0,0 +1,308 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagMakerDescribe(t *testing.T) {
+	type C struct {
+		Name    string `yaml:"label"`
+		Timeout time.Duration
+		Hosts   []string
+		Level   int8
+	}
+	c := &C{Name: "svc", Timeout: time.Second}
+	infos, err := NewFlagMaker().Describe(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []FlagInfo{
+		{Name: "hosts", Type: "[]string", Usage: "hosts", Default: "[]"},
+		{Name: "label", Type: "string", Usage: "label", Default: "svc"},
+		{Name: "level", Type: "int8", Usage: "level", Default: "0"},
+		{Name: "timeout", Type: "time.Duration", Usage: "timeout", Default: "1s"},
+	}, infos)
+
+	_, err = NewFlagMaker().Describe(*c)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerRedactMatching(t *testing.T) {
+	type C struct {
+		Name  string
+		Token string
+		Hosts []string
+	}
+	c := &C{Name: "svc", Token: "ghp_abcdef0123456789", Hosts: []string{"a", "ghp_0123456789abcdef"}}
+	fm := NewFlagMaker()
+	fm.RedactMatching(regexp.MustCompile(`^ghp_[0-9a-z]{16}$`))
+	infos, err := fm.Describe(c)
+	assert.Nil(t, err)
+	defaults := make(map[string]string)
+	for _, info := range infos {
+		defaults[info.Name] = info.Default
+	}
+	assert.Equal(t, map[string]string{"name": "svc", "token": "****", "hosts": "[a ****]"}, defaults)
+
+	assert.Equal(t, []string{"--hosts=a", "--hosts=****", "--name=svc", "--token=****"}, fm.ToArgs(c))
+	env := fm.ToEnviron(c, "app")
+	assert.Contains(t, env, "APP_TOKEN=****")
+	assert.Contains(t, env, "APP_HOSTS=a,****")
+
+	// it's display only
+	_, err = fm.ParseArgs(c, []string{"--token", "ghp_fedcba9876543210"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ghp_fedcba9876543210", c.Token)
+}
+
+func TestFlagMakerDescribeNilStructPtr(t *testing.T) {
+	type Sub struct {
+		Host string
+		Port int
+	}
+	type C struct {
+		Name string
+		Sub  *Sub
+	}
+	c := &C{}
+	infos, err := NewFlagMaker().Describe(c)
+	assert.Nil(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"name", "sub.host", "sub.port"}, names)
+
+	// the inspection leaves the object as is
+	assert.Nil(t, c.Sub)
+	NewFlagMaker().ToArgs(c)
+	NewFlagMaker().ToEnviron(c, "app")
+	_, err = NewFlagMaker().Schema(c)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sub)
+}
+
+func TestFlagMakerDescribeDeprecated(t *testing.T) {
+	type C struct {
+		OldHost string `flag:",deprecated=use --host"`
+		OldPort int    `flag:",deprecated"`
+		Host    string
+	}
+	infos, err := NewFlagMaker().Describe(&C{})
+	assert.Nil(t, err)
+	deprecated := make(map[string]string)
+	for _, info := range infos {
+		deprecated[info.Name] = info.Deprecated
+	}
+	assert.Equal(t, map[string]string{
+		"host":    "",
+		"oldhost": "use --host",
+		"oldport": "deprecated",
+	}, deprecated)
+}
+
+func TestShortDuration(t *testing.T) {
+	cases := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{0, "0s"},
+		{time.Hour, "1h"},
+		{3600 * time.Second, "1h"},
+		{90 * time.Second, "90s"},
+		{2 * time.Minute, "2m"},
+		{1500 * time.Millisecond, "1500ms"},
+		{3 * time.Microsecond, "3us"},
+		{7, "7ns"},
+		{-time.Hour, "-1h"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, shortDuration(c.d), c.d.String())
+	}
+}
+
+func TestFlagMakerDescribeShortDurations(t *testing.T) {
+	type C struct {
+		Interval time.Duration
+		Timeout  time.Duration
+	}
+	c := &C{Interval: time.Hour, Timeout: 90 * time.Second}
+	defaults := func(fm *FlagMaker) map[string]string {
+		infos, err := fm.Describe(c)
+		assert.Nil(t, err)
+		m := make(map[string]string)
+		for _, info := range infos {
+			m[info.Name] = info.Default
+		}
+		return m
+	}
+	assert.Equal(t, map[string]string{"interval": "1h0m0s", "timeout": "1m30s"}, defaults(NewFlagMaker()))
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, TagName: "yaml", ShortDurations: true})
+	assert.Equal(t, map[string]string{"interval": "1h", "timeout": "90s"}, defaults(fm))
+}
+
+func TestDescribeUsages(t *testing.T) {
+	type C struct {
+		Name    string
+		Network struct {
+			Timeout int
+		}
+	}
+	fm := NewFlagMaker()
+	fm.SetUsages(map[string]string{"network.timeout": "timeout of the requests in seconds"})
+	infos, err := fm.Describe(&C{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, "name", infos[0].Usage)
+	assert.Equal(t, "timeout of the requests in seconds", infos[1].Usage)
+
+	// the flags already defined are updated as well
+	var c C
+	_, err = fm.ParseArgs(&c, nil)
+	assert.Nil(t, err)
+	fm.SetUsages(map[string]string{"name": "name of the service"})
+	infos, err = fm.Describe(&c)
+	assert.Nil(t, err)
+	assert.Equal(t, "name of the service", infos[0].Usage)
+	assert.Equal(t, "timeout of the requests in seconds", fm.fs.Lookup("network.timeout").Usage)
+	assert.Equal(t, "name of the service", fm.fs.Lookup("name").Usage)
+}
+
+func TestDescribeGroups(t *testing.T) {
+	type C struct {
+		Name    string
+		Network struct {
+			Port  int
+			Proxy string `group:"proxy"`
+		} `group:"networking"`
+		Timeout int `group:"networking"`
+	}
+	fm := NewFlagMaker()
+	infos, err := fm.Describe(&C{})
+	assert.Nil(t, err)
+	groups := make(map[string]string)
+	for _, info := range infos {
+		groups[info.Name] = info.Group
+	}
+	assert.Equal(t, map[string]string{
+		"name":          "",
+		"network.port":  "networking",
+		"network.proxy": "proxy",
+		"timeout":       "networking",
+	}, groups)
+
+	out, err := fm.DescribeJSON(&C{})
+	assert.Nil(t, err)
+	var sections map[string][]FlagInfo
+	assert.Nil(t, json.Unmarshal(out, &sections))
+	assert.Equal(t, 3, len(sections))
+	assert.Equal(t, 2, len(sections["networking"]))
+	assert.Equal(t, "network.port", sections["networking"][0].Name)
+	assert.Equal(t, "timeout", sections["networking"][1].Name)
+	assert.Equal(t, "name", sections[DefaultGroup][0].Name)
+}
+
+func TestFlagMakerSchema(t *testing.T) {
+	type C struct {
+		Region  string        `flag:",oneof=us-east us-west,required"`
+		Name    string        `flag:",maxbytes=16"`
+		Timeout time.Duration `flag:",mindur=1s,strictmin"`
+		Hosts   []string
+	}
+	fm := NewFlagMaker()
+	b, err := fm.Schema(&C{Timeout: 2 * time.Second})
+	assert.Nil(t, err)
+	var fields []SchemaField
+	assert.Nil(t, json.Unmarshal(b, &fields))
+	assert.Equal(t, []SchemaField{
+		{FlagInfo: FlagInfo{Name: "hosts", Type: "[]string", Usage: "hosts", Default: "[]"}, Multiple: true},
+		{FlagInfo: FlagInfo{Name: "name", Type: "string", Usage: "name"}, MaxBytes: 16},
+		{FlagInfo: FlagInfo{Name: "region", Type: "string", Usage: "region"}, Required: true, OneOf: []string{"us-east", "us-west"}},
+		{FlagInfo: FlagInfo{Name: "timeout", Type: "time.Duration", Usage: "timeout", Default: "2s"}, MinDuration: "1s", StrictMin: true},
+	}, fields)
+
+	args, err := fm.SchemaArgs(&C{}, map[string][]string{
+		"region": {"us-west"},
+		"hosts":  {"a", "b"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--hosts=a", "--hosts=b", "--region=us-west"}, args)
+	c := &C{}
+	_, err = fm.ParseArgs(c, args)
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Region: "us-west", Hosts: []string{"a", "b"}}, c)
+
+	_, err = fm.SchemaArgs(&C{}, map[string][]string{"region": {"us-west", "us-east"}})
+	assert.Error(t, err)
+	_, err = fm.SchemaArgs(&C{}, map[string][]string{"unknown": {"x"}})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerDescribeVisibleIf(t *testing.T) {
+	type C struct {
+		Mode   string
+		Tuning int `flag:",visibleif=mode=advanced"`
+	}
+	names := func(c *C) []string {
+		infos, err := NewFlagMaker().Describe(c)
+		assert.Nil(t, err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"mode"}, names(&C{Mode: "basic"}))
+	assert.Equal(t, []string{"mode", "tuning"}, names(&C{Mode: "advanced"}))
+
+	c := &C{}
+	_, err := NewFlagMaker().ParseArgs(c, []string{"--tuning", "3"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Tuning)
+
+	type Bad struct {
+		Tuning int `flag:",visibleif=mode=advanced"`
+	}
+	_, err = NewFlagMaker().Describe(&Bad{})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerRepeatable(t *testing.T) {
+	type C struct {
+		Name    string
+		Hosts   []string
+		Labels  map[string]bool
+		Verbose int `flag:",count"`
+	}
+	fm := NewFlagMaker()
+	assert.Equal(t, map[string]bool{
+		"name":    false,
+		"hosts":   true,
+		"labels":  true,
+		"verbose": true,
+	}, fm.Repeatable(&C{}))
+	assert.Nil(t, fm.Repeatable(C{}))
+}
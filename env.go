@@ -0,0 +1,146 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envSliceSep separates the elements of multi-value flags in environment
+// variables.
+const envSliceSep = ","
+
+// envName returns the environment variable name corresponding to a flag,
+// e.g. PREFIX_NETWORK_TCP_READTIMEOUT for network.tcp.readtimeout or
+// /network/tcp/readtimeout.
+func envName(prefix, name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if len(prefix) > 0 {
+		name = prefix + "_" + name
+	}
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_", "/", "_").Replace(name))
+}
+
+// ParseEnviron overrides the fields of obj with the environment variables
+// found in environ, which is typically obtained from os.Environ(). The
+// variable of a flag is named after the flag, upper cased, with dots replaced
+// by underscores and prefixed by prefix and an underscore. The values of
+// multi-value flags are separated by commas, an empty value leaves the field
+// unchanged. If a variable is invalid, all the fields are left unchanged.
+//
+// The flags are defined on the FlagMaker, so ParseArgs can later be called
+// with the same object to let command line arguments win over the
+// environment.
+func (fm *FlagMaker) ParseEnviron(obj interface{}, prefix string, environ []string) error {
+	var changes []Change
+	// as in ParseArgsFS, the Logger is told once unlocked
+	defer func() { fm.logChanges(changes) }()
+	defer fm.lock()()
+	if err := fm.defineFlags(obj); err != nil {
+		return err
+	}
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i >= 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	fm.beginParse()
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		key := envName(prefix, f.Name)
+		val, ok := env[key]
+		if !ok {
+			return
+		}
+		vals := []string{val}
+		if _, ok := baseValue(f.Value).(multiValue); ok {
+			if len(val) == 0 {
+				return
+			}
+			vals = strings.Split(val, envSliceSep)
+		}
+		for _, v := range vals {
+			if e := fm.fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for environment variable %s: %v", v, key, e)
+				if fm.formatted != nil {
+					err = fm.formatted
+				}
+				return
+			}
+		}
+		fm.record(f.Name, "env")
+	})
+	if err == nil {
+		err = fm.collectedErr()
+	}
+	if err == nil {
+		fm.endParse()
+		err = fm.recomputeDefaults()
+	}
+	if err != nil {
+		fm.rollback()
+	} else {
+		changes = fm.applied()
+	}
+	return err
+}
+
+// ToEnviron renders the current values of the fields of obj as environment
+// variable assignments, e.g. PREFIX_NETWORK_TCP_READTIMEOUT=10ms, sorted by
+// flag name. It's the inverse of ParseEnviron: elements of multi-value flags
+// are joined with commas, so they shouldn't contain commas themselves, and nil
+// pointers are omitted. The values of secret fields are masked unless the
+// RevealSecrets option is set. nil is returned if obj cannot have flags
+// defined for.
+func (fm *FlagMaker) ToEnviron(obj interface{}, prefix string) []string {
+	r, err := fm.inspect(obj)
+	if err != nil {
+		return nil
+	}
+
+	var environ []string
+	r.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := r.alternates[f.Name]; ok {
+			return
+		}
+		if l, ok := f.Value.(*lazyValue); ok && l.isNil() {
+			// nil optional values are left unset
+			return
+		}
+		val := r.redact(f.Value.String())
+		if mv, ok := baseValue(f.Value).(multiValue); ok {
+			vals, _ := r.redactValues(mv.values())
+			val = strings.Join(vals, envSliceSep)
+		}
+		if r.masked(f.Name) {
+			val = secretMask
+		}
+		environ = append(environ, envName(prefix, f.Name)+"="+val)
+	})
+	return environ
+}
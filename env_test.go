@@ -0,0 +1,153 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package flags
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagMakerToEnviron(t *testing.T) {
+	cfg := &Cfg1{}
+	cfg.network.tcp.ReadTimeout = 10 * time.Millisecond
+	cfg.logging.Path = "/var/log"
+
+	environ := NewFlagMaker().ToEnviron(cfg, "app")
+	assert.Contains(t, environ, "APP_NETWORK_TCP_READTIMEOUT=10ms")
+	assert.Contains(t, environ, "APP_LOGGING_PATH=/var/log")
+	assert.Contains(t, environ, "APP_LOGGING_INTERVAL=0")
+
+	assert.Nil(t, NewFlagMaker().ToEnviron(*cfg, "app"))
+}
+
+func TestFlagMakerEnvironRoundTrip(t *testing.T) {
+	type C struct {
+		Name    string
+		Hosts   []string
+		Ports   []int
+		Weights []float64
+		Timeout time.Duration
+		Debug   bool
+		Sub     struct {
+			Level int8
+		}
+	}
+	src := &C{
+		Name:    "svc",
+		Hosts:   []string{"h1", "h2"},
+		Ports:   []int{80, 443},
+		Timeout: time.Second,
+		Debug:   true,
+	}
+	src.Sub.Level = 3
+
+	environ := NewFlagMaker().ToEnviron(src, "")
+	assert.Contains(t, environ, "HOSTS=h1,h2")
+	assert.Contains(t, environ, "SUB_LEVEL=3")
+
+	dst := &C{Weights: []float64{1.5}}
+	err := NewFlagMaker().ParseEnviron(dst, "", environ)
+	assert.Nil(t, err)
+	// empty slices leave the field unchanged
+	src.Weights = []float64{1.5}
+	assert.Equal(t, src, dst)
+}
+
+func TestFlagMakerParseEnviron(t *testing.T) {
+	cfg := &Cfg1{}
+	fm := NewFlagMaker()
+	err := fm.ParseEnviron(cfg, "app", []string{
+		"APP_LOGGING_PATH=/env/log",
+		"APP_LOGGING_INTERVAL=5",
+		"OTHER=1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "/env/log", cfg.Path)
+
+	// arguments win over the environment when parsed afterwards
+	args, err := fm.ParseArgs(cfg, []string{"--logging.path", "/arg/log"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, "/arg/log", cfg.Path)
+	assert.Equal(t, 5, cfg.Interval)
+
+	_, err = fm.ParseArgs(&Cfg1{}, nil)
+	assert.Error(t, err)
+
+	err = NewFlagMaker().ParseEnviron(&Cfg1{}, "app", []string{"APP_LOGGING_INTERVAL=x"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "APP_LOGGING_INTERVAL")
+}
+
+func TestFlagMakerToEnvironNilPtr(t *testing.T) {
+	type C struct {
+		Name  *string
+		Level *int
+	}
+	level := 3
+	environ := NewFlagMaker().ToEnviron(&C{Level: &level}, "")
+	assert.Equal(t, []string{"LEVEL=3"}, environ)
+
+	c := &C{}
+	assert.Nil(t, NewFlagMaker().ParseEnviron(c, "", environ))
+	assert.Nil(t, c.Name)
+	assert.Equal(t, &level, c.Level)
+}
+
+func TestFlagMakerProvenance(t *testing.T) {
+	type C struct {
+		Host  string
+		Port  int
+		Level int `flag:"level|verbosity"`
+		Name  string
+		Y     string `flag:",env=FLAGS_TEST_Y,source=flag"`
+	}
+	t.Setenv("FLAGS_TEST_Y", "env")
+	fm := NewFlagMaker()
+	c := &C{}
+	assert.Nil(t, fm.ParseEnviron(c, "app", []string{"APP_HOST=env.local", "APP_PORT=80"}))
+	_, err := fm.ParseArgs(c, []string{"--port", "8080", "--verbosity=2", "--y", "arg"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"host":  "env",
+		"port":  "flag",
+		"level": "flag",
+		"name":  "default",
+		"y":     "env",
+	}, fm.Provenance())
+
+	// a failed parse leaves the provenance unchanged
+	_, err = fm.ParseArgs(c, []string{"--name", "x", "--port", "http"})
+	assert.Error(t, err)
+	assert.Equal(t, "default", fm.Provenance()["name"])
+
+	// it doesn't wait for the Locker option, e.g. held by a parse
+	var mu sync.Mutex
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Locker: &mu})
+	_, err = fm.ParseArgs(c, []string{"--name", "x"})
+	assert.Nil(t, err)
+	mu.Lock()
+	assert.Equal(t, "flag", fm.Provenance()["name"])
+	mu.Unlock()
+}
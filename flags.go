@@ -106,6 +106,12 @@
 //   -network.writetimeout duration
 //         network.writetimeout
 //
+// For fully dynamic configs, the top level object can also be a pointer to a
+// map[string]string or a map[string]interface{}, in which case each flag is
+// stored verbatim as a key, e.g. --name value gives {"name": "value"}. For a
+// sync.Map, BindSyncMap defines the flags of a schema giving the kind of the
+// value of each key, e.g. reflect.Int, and stores the values parsed.
+//
 // flags to subcommands are naturally suported.
 //
 //   func main() {
@@ -170,50 +176,719 @@
 //   -user string
 //         user
 //
-// Please be aware that usual GoLang flag creation rules apply, i.e., if there are
+// Fields with the keeppath option, e.g. `flag:",keeppath"`, keep their
+// namespaced name though, which helps avoiding collisions between fields with
+// the same name.
+//
+// With the CollapseSingles option, embedded structs which have a single field
+// don't add their name to the one of the field, e.g. the field Value of an
+// embedded struct wrapper is named value rather than wrapper.value. The names
+// which collide once collapsed are reported as errors.
+//
+// The FieldFilter option selects the fields which have flags at runtime rather
+// than with tags. It's called for each field with the names of the fields
+// leading to it, including its own, e.g. []string{"network", "tcp"}, and no
+// flag is created for a field it returns false for, nor for the fields of a
+// struct field, e.g. to leave out the settings of a disabled component.
+//
+// Lower casing runs the words of field names together, e.g. httpport for
+// HTTPPort. With the NameStyle option set to KebabCase or SnakeCase, they're
+// split instead, minding acronyms, e.g. http-port, db-name and xml-id for
+// HTTPPort, DBName and XMLID.
+//
+// Please be aware that flag names must be unique, i.e., if there are
 // duplication in flag names (in the flattened case it's more likely to happen
-// unless the caller make due dilligence to create the struct properly), it panics.
+// unless the caller make due dilligence to create the struct properly), the
+// parse fails with an error such as "flag name is defined twice", rather than
+// panicking as the flag package would.
 //
 //
-// Note that not all types can have command line flags created for. map, channel
-// and function type will not defien a flag corresponding to the field. Pointer
+// Note that not all types can have command line flags created for. map (except
+// map[string]bool and map[string]string), channel and function type will not defien a flag
+// corresponding to the field. Neither will uintptr and unsafe.Pointer. The
+// fields skipped that way are listed by Unsupported, with the reason. Pointer
 // types are properly handled and slice type will create multi-value command
 // line flags. That is, e.g. if a field foo's type is []int, one can use
 // --foo 10 --foo 15 --foo 20 to override this field value to be
-// []int{10, 15, 20}. For now, only []int, []string and []float64 are supported
-// in this fashion.
+// []int{10, 15, 20}. The slices supported in this fashion are []int,
+// []string, []float64, []bool, []time.Time, []net.IP, []map[string]string
+// and, with the runes option, []rune, as detailed below, while the slices of
+// structs have flags for the fields of their elements. Elements of
+// []time.Time are parsed as RFC3339 unless the field has a layout option,
+// e.g. `flag:",layout=2006-01-02"`.
+// Each value of a []rune field with the runes option, e.g. `flag:",runes"`,
+// appends all of its characters, e.g. --delims , --delims ";:" gives
+// []rune{',', ';', ':'}. Since rune is int32, the option is required so that
+// []int32 fields aren't taken for characters.
+// Elements of []net.IP are parsed with net.ParseIP, e.g. --dns 8.8.8.8
+// --dns 1.1.1.1.
+// The elements of a []int field with the ranges option, e.g. `flag:",ranges"`,
+// can be given as comma separated values and ranges, e.g. --ports 80,8000-8002
+// gives 80, 8000, 8001 and 8002. Likewise, with the coerce option, e.g.
+// `flag:",coerce"`, they can be given as integral floats, e.g. 3.0 for
+// loosely typed sources, while 3.5 is rejected.
+// A map[string]bool field takes repeated key=value flags, a bare key meaning
+// true, e.g. --features x=true --features y=false --features z. Like slices,
+// the first value replaces the map and the following ones add keys. A key given
+// twice keeps its last value, unless the dupkeys option says otherwise:
+// `flag:",dupkeys=first"` keeps the first value, and `flag:",dupkeys=error"`
+// rejects the second one. Likewise, a map[string]string field takes repeated
+// key=value flags, e.g. --labels k1=v1 --labels k2=v2, the value being
+// everything after the first =, and the same dupkeys option.
+// Each value of a []map[string]string flag appends a map parsed from comma
+// separated key=value pairs, e.g. --rules k1=v1,k2=v2 --rules k1=v3.
+// net.HardwareAddr is not a slice flag though, it takes a single MAC address
+// parsed with net.ParseMAC. A *big.Rat field takes an exact fraction, e.g.
+// 1/3 or 0.25, and is allocated when set. A *time.Location field takes a
+// zone name resolved with time.LoadLocation, e.g. America/New_York or UTC.
+// A *regexp.Regexp field takes an expression compiled with regexp.Compile,
+// within the CompileTimeout option if set.
+// The fields of an integer enum type registered with RegisterEnum, e.g. a
+// protobuf enum, take the names of the values of the enum.
+// A color.RGBA field takes a hex color, #RRGGBB or #RRGGBBAA, e.g. #112233,
+// which is opaque, or #11223344.
+// The fields of a type registered with RegisterCodec, e.g. a struct holding the
+// parts of a DSN, take a single value decoded by the codec.
+// A json.Number field keeps the number given verbatim, e.g. a large integer
+// which a float64 couldn't hold exactly, once checked it's a JSON number.
+//
+// An integer field with the count option, e.g. `flag:",count"`, counts how
+// many times its flag is given, e.g. -v -v -v gives 3. A value can still be
+// given, e.g. -v=5, and -v=false resets the count.
+//
+// An int64 field with the durationms option, e.g. `flag:",durationms"`, takes
+// a duration on the command line, e.g. --timeout 5s, and stores it as a number
+// of milliseconds, e.g. 5000, rejecting the durations which aren't a whole
+// number of milliseconds. The duration option stores another unit, given as
+// its value, one of ns, us, ms, s, m and h, e.g. `flag:",duration=ns"`. This
+// bridges configs which predate time.Duration.
+// A duration field with the clock option, e.g. `flag:",clock"`, also takes a
+// clock time, e.g. 01:30:00 for 1h30m, friendlier for schedule configs.
+//
+// A field can have several names, given in its flag tag, e.g.
+// `flag:"timeout|readtimeout|read-timeout"`. Any of them sets the field, the
+// last one given winning, and the first one is the name of the flag, e.g. in
+// Describe. With the DashAliases option, each dotted flag also gets its
+// dash-joined name, e.g. -network-tcp-readtimeout, for legacy scripts.
+// Likewise, the ValueSeparator option accepts another separator between the
+// name and the value of a flag, e.g. ":" for --name:value.
+//
+// The value of a flag which isn't given can be taken from other sources, tried
+// in the order of the tag until one has a value, e.g.
+// `flag:",env=MYAPP_X,file=/etc/x,default=5"`: the environment variable
+// MYAPP_X, then the content of the file /etc/x if it exists, then 5. The
+// arguments come first, unless they're placed otherwise with source=flag, e.g.
+// `flag:",env=MYAPP_X,source=flag"`. An invalid value from a source fails the
+// parse.
+//
+// A map[string]string field with the catchall option, e.g.
+// `flag:",catchall=ext."`, receives the undefined flags starting with the
+// prefix, keyed by their name without the prefix, e.g. --ext.foo bar gives
+// map[foo:bar]. The flags which are defined take precedence.
+//
+// A multi-value flag with the collect option, e.g. `flag:",collect"`, reports
+// all its invalid values at once rather than only the first one. As usual, the
+// field is left unchanged if any value is invalid.
+//
+// The values of a field of a named type can be parsed as another type of the
+// same kind with the as option, e.g. `flag:",as=duration"` for a type Timeout
+// int64, or `flag:",as=uint16"` for a type Port uint16.
+//
+// The durations below a floor given with the mindur option, e.g.
+// `flag:",mindur=1s"`, are raised to the floor with a warning, or rejected
+// with the strictmin option as well.
+//
+// The characters of the cutset given with the cut option, e.g. `flag:",cut=/"`,
+// are trimmed from both ends of the values of a string flag, e.g. /path/ gives
+// path. The other flags ignore the option.
+//
+// The paths given to a flag with the clean option, e.g. `flag:",clean"`, are
+// cleaned with filepath.Clean, e.g. a/../b gives b. With `flag:",clean=abs"`,
+// they're also made absolute with filepath.Abs, relative to the working
+// directory. Empty values are left empty rather than turned into the working
+// directory.
+//
+// The hosts given to a flag with the resolvable option, e.g.
+// `flag:",resolvable"`, are looked up when set, so that typos are caught
+// early. A host:port value has its host looked up. The lookups go through the
+// Resolver option, within LookupTimeout, and are disabled by SkipLookups.
+//
+// Likewise, the paths given to a flag with the mustexist option, e.g.
+// `flag:",mustexist"`, must exist when set, and be a directory with
+// `flag:",mustexist=dir"` or not one with `flag:",mustexist=file"`. The paths
+// are looked up with the Stat option, and the checks are disabled by
+// SkipStats.
+//
+// A flag with the optarg option, e.g. `flag:",optarg=always"`, takes an
+// optional value, GNU style: --color alone sets the field to the value of the
+// option, always, while --color=never sets it to never. Since the value is
+// optional, it must follow an =, so --color never leaves never as an
+// argument.
+//
+// The values accepted by a flag can be restricted with the oneof option, e.g.
+// `flag:",oneof=us-east us-west"`. For slices, each element is checked and an
+// invalid element discards the whole override of the field. Likewise, the
+// maxbytes option, e.g. `flag:",maxbytes=256"`, rejects the raw values longer
+// than the given number of bytes, before they're transformed.
+//
+// Nil pointers to structs are allocated so that flags can be created for the
+// fields of the structs. Other nil pointers stay nil unless their flag is set,
+// which makes e.g. *bool fields tri-state: nil if --feature isn't given, true
+// with --feature and false with --feature=false.
+//
+// A flag can be marked as deprecated with the deprecated option, e.g.
+// `flag:",deprecated=use --newflag; removed in 2.0"`. The flag still works,
+// but setting it reports the message as a warning.
+//
+// The elements of a slice can be sorted in their natural order with the sort
+// option and duplicates can be removed with the dedup option, e.g.
+// `flag:",sort,dedup"`. This is done once all the values are applied.
+//
+// A value of a slice with the lines option, e.g. `flag:",lines"`, is split on
+// newlines, each non-blank line being trimmed and appended as an element.
+// With the jsonarray option, e.g. `flag:",jsonarray"`, a value is a JSON
+// array whose elements are appended, e.g. --hosts '["a","b"]' or
+// --ports '[80,443]', and [] leaves an empty slice.
+//
+// The raw values of a field can be rewritten before being parsed with the
+// transform option, e.g. `flag:",transform=home"`, where the transform is
+// registered with RegisterTransform. Likewise, the accepted values of string
+// fields can be stored in a canonical form, e.g. a lower case host name, with
+// the canonical option, e.g. `flag:",canonical=host"`, where the canonicalizer
+// is registered with RegisterCanonicalizer.
+//
+// A flag with the required option, e.g. `flag:",required"`, must be set,
+// otherwise ParseArgs fails. With the requiredif option, e.g.
+// `flag:",requiredif=mode=prod"`, it's only required if the field named mode
+// of the same struct is prod once parsed.
+//
+// A field can also be set from a positional argument, i.e. one of the
+// arguments left after the flags, with the positional option, e.g.
+// `flag:",positional=0"` for the first one. The positional arguments set
+// fields are not returned by ParseArgs.
+//
+// If a struct (the top level one or any nested one) has a Defaults() method,
+// it's called before the flags for its fields are created, so that default
+// values can be populated by the config type itself. Since a parent struct is
+// visited before its children, the Defaults() of a nested struct runs after
+// the one of its parent. Note that methods of embedded structs are promoted,
+// so Defaults() should be idempotent.
+//
+// Besides command line arguments, fields can be overridden from environment
+// variables with ParseEnviron, where the flag logging.path with the prefix
+// "app" corresponds to APP_LOGGING_PATH. ToEnviron does the inverse and
+// renders the current values of a struct as such variables, and ToArgs as
+// arguments. The values of the fields with the secret option, e.g.
+// `flag:",secret"`, are rendered as ****, as are their defaults given by
+// Describe and their values in the errors of VerifyUnchanged, unless the
+// RevealSecrets option is set. Values of other fields can be masked as well, e.g. anything looking
+// like a token, with RedactMatching, which also applies to Describe.
+//
+// The flags can also be defined on a FlagSet owned by the caller with
+// RegisterInto, so that they are parsed along with other flags by a single
+// fs.Parse call. A name already defined on the FlagSet results in an error.
+//
+// ParseArgsCopy applies the arguments to a deep copy of the object and
+// returns it, leaving the object untouched, e.g. to reload an immutable
+// config. Likewise, Preview returns the changes the arguments would make,
+// with the old and new values of the flags, e.g. to ask for confirmation.
+//
+// To parse into many objects of the same type, e.g. in a hot reload loop,
+// Compile defines the flags once and returns a CompiledBinder, whose Parse
+// copies the fields backed by flags in and out of the object rather than
+// walking it again.
+//
+// With the JSONPointer option, the flags are named after the JSON pointer of
+// their field instead, e.g. --/network/tcp/readtimeout 5ms, regardless of
+// Flatten. Only the pointer form is defined then, so there is no ambiguity
+// between the two forms.
+//
+// Defaults depending on other fields can be computed with the DefaultFunc
+// option, keyed by flag name, e.g. to default advertiseaddr to bindaddr. The
+// function is called with the object once the flags are defined, only if the
+// field is zero, and again once the arguments are parsed unless the flag was
+// overridden, so that the default follows the parsed values. The defaults are
+// computed in order of flag name, after the ones listed in DefaultOrder. They
+// aren't values given by the user, so options such as deprecated or confirm
+// don't apply to them.
+//
+// The values of a slice with the append option, e.g. `flag:",append"`, are
+// appended to the elements it has before the parse, e.g. its defaults, rather
+// than replacing them. The existing elements stay in front, followed by the
+// values in the order they're given.
+//
+// An empty value, e.g. --name=, sets a string field to the empty string. For
+// slices and maps it's not added as an element but clears the field instead,
+// e.g. --hosts= leaves an empty slice, even with the append option. Values
+// given after it are added to the cleared field, including empty ones, e.g.
+// --hosts= --hosts= gives []string{""}, as rendered by ToArgs. Likewise, with the
+// UnsetToken option set to e.g. "unset", --count=unset resets a scalar field
+// to the zero value of its type, and a pointer to nil.
+//
+// Whether an empty slice ends up nil or not depends on its value before the
+// parse. The NormalizeSlices option makes it consistent, e.g. to marshal it
+// to JSON as null with NilSlices, or as [] with EmptySlices.
+//
+// With the ResolveRefs option, references to other flags in string fields, e.g.
+// --log.path '${data.dir}/log', are substituted with the values of the flags
+// once parsed. References to undefined flags and cyclic references make
+// ParseArgs fail.
+//
+// The usage message of a flag defaults to its name. Usage messages kept apart
+// from the struct, e.g. generated from the comments of the fields, can be set
+// by flag name with SetUsages, and are shown by PrintDefaults and Describe.
+//
+// To catch code modifying the configuration after it's loaded, Freeze records
+// the values of the fields backed by flags, and VerifyUnchanged returns an error
+// if any of them changed since.
+//
+// For audit trails, the Logger option is told the old and new values of each
+// flag whose field a parse changed. Flags set to the value their field
+// already has aren't reported.
+//
+// To find out why a field has its value, Provenance tells for each flag the
+// source of the value of its field: flag for the arguments, env for
+// ParseEnviron or an env source, file or default for a file or default
+// source, profile for a profile and default for fields no parse set. Fields
+// set by composite flags keep the source they had.
+//
+// A config shared with concurrent readers can be updated in place by setting
+// the Locker option to the write lock guarding it, e.g. a *sync.RWMutex,
+// which is held while the fields are set.
+//
+// Constraints spanning several fields can be checked once the overrides are
+// applied with AddCrossValidator. The validate subpackage provides such a
+// validator running go-playground/validator over the validate tags of the
+// object, e.g. `validate:"min=1"`. Finalization local to a struct type, e.g.
+// computing derived fields, can be registered with RegisterFinalizer instead,
+// and is run for each instance of the type before the cross validators.
+//
+// Flags can be sectioned with the group struct tag, e.g. `group:"networking"`,
+// which applies to the fields of a struct as well, unless they have their own
+// group. DescribeJSON returns the flags by group, the ones without a group
+// being in the DefaultGroup. Groups don't affect parsing.
+//
+// Repeatable tells which flags accumulate their values when given more than
+// once, i.e. the ones of slices and maps and the ones with the count option,
+// e.g. for help texts and completion. With the StrictRepeats option, giving
+// any other flag more than once makes ParseArgs fail. Likewise, with the
+// MaxArgs option, giving more flags than it allows makes ParseArgs fail,
+// e.g. to guard services taking overrides over the wire.
+//
+// Presets of the object, e.g. small, medium or large deployments, can be
+// registered with RegisterProfile and selected with --profile, e.g.
+// --profile large. The preset is applied before the other flags, which
+// override its values.
+//
+// A flag with the visibleif option, e.g. `flag:",visibleif=mode=advanced"`,
+// is only listed by Describe, DescribeJSON and Schema when the sibling field
+// it names has the value it gives in the object described. It's parsed as
+// usual though.
+//
+// The values of a flag known only at runtime, e.g. the names of plugins, can be
+// restricted with SetAllowed, which works like the oneof option.
+//
+// Schema returns the flags along with the constraints of their fields, e.g.
+// oneof or maxbytes, as JSON for tools rendering forms to edit them, and
+// SchemaArgs turns the values submitted back into arguments for ParseArgs.
+//
+// A bool flag with the confirm option, e.g. `flag:",confirm"`, can only be
+// enabled along with --yes, which is defined unless the struct has its own yes
+// flag. With a token, e.g. `flag:",confirm=I-UNDERSTAND"`, the flag is instead
+// enabled by giving the token as its value, e.g. --allowdataloss=I-UNDERSTAND.
+//
+// The ValueInterceptor option is called with every raw value before the tag
+// options are applied, e.g. to log, rewrite or reject values in a single place.
+//
+// The ErrorFormatter option turns the errors of the values rejected into the
+// errors returned, e.g. to localize them.
+//
+// With the SingularizeSlices option, the flags of slices are named after the
+// singular form of their field, e.g. --host a --host b fills Hosts. Irregular
+// plurals can be given with the Singulars option.
+//
+// Parsing is transactional: if ParseArgs or ParseEnviron fails, e.g. because a
+// value is invalid or a validator rejects the result, all the fields backed by
+// flags are restored to their values before the parse.
+//
+// The fields of the elements of a slice of structs have flags prefixed by the
+// index of the element, e.g. --workers.0.name x for Workers []Worker. Flags
+// are only created for the existing elements, unless the field has the grow
+// option along with a maxlen one, e.g. `flag:",grow,maxlen=8"`, in which case
+// they're created up to the maximum length, and setting one grows the slice up
+// to its element, e.g. --workers.3.name x gives a slice of length 4 at least,
+// the intermediate elements being zero.
+//
+// Renamed flags can keep their old names as deprecated aliases loaded with
+// LoadAliases from old=new lines, e.g. from a file, rather than from struct
+// tags. Setting an alias sets the new flag, with a deprecation warning.
+//
+// A flag without a field, e.g. --production, can apply several settings at once
+// with RegisterComposite. Composite flags are applied before the other flags,
+// wherever they appear, so that the flags given explicitly win.
 package flags
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image/color"
+	"io"
+	"math/big"
+	"net"
+	"os"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 // FlagMakingOptions control the way FlagMaker's behavior when defining flags.
 type FlagMakingOptions struct {
 	// Use lower case flag names rather than the field name/tag name directly.
 	UseLowerCase bool
-	// Create flags in namespaced fashion
+	// NameStyle, if set, splits the names taken from field names into lower
+	// case words, minding acronyms, e.g. http-port for HTTPPort with
+	// KebabCase. The names given by tags are left as is.
+	NameStyle NameStyle
+	// Create flags in namespaced fashion. Fields with the keeppath option,
+	// e.g. `flag:",keeppath"`, keep their namespaced name though.
 	Flatten bool
 	// If there is a struct tag named 'TagName', use its value as the flag name.
 	// The purpose is that, for yaml/json parsing we often have something like
 	// Foobar string `yaml:"host_name"`, in which case the flag will be named
-	// 'host_name' rather than 'foobar'.
+	// 'host_name' rather than 'foobar'. The options of the tag are ignored,
+	// e.g. `json:"timeout,omitempty"` gives timeout, and `json:",omitempty"`
+	// the field name. A dotted name, e.g. `json:"tcp.readtimeout"`, stands for
+	// nested fields, e.g. in JSON pointers.
 	TagName string
+	// Omit the name of embedded structs which have a single field, e.g. the
+	// field Value of an embedded struct wrapper is named value rather than
+	// wrapper.value. Name collisions are then reported as errors.
+	CollapseSingles bool
+	// FieldFilter, if set, is called for each field of the structs being
+	// walked, with the names of the fields leading to it including its own.
+	// If it returns false, no flags are created for the field, nor for the
+	// fields of a struct field.
+	FieldFilter func(path []string, field reflect.StructField) bool
+	// Show durations in the largest unit they're a whole number of in
+	// Describe, e.g. 1h rather than 1h0m0s, but 90s rather than 1m30s. This
+	// doesn't affect parsing.
+	ShortDurations bool
+	// Name the flags after the JSON pointer of their field, e.g.
+	// --/network/tcp/readtimeout rather than --network.tcp.readtimeout. It
+	// takes precedence over Flatten.
+	JSONPointer bool
+	// Substitute the references to other flags in string fields once parsed,
+	// e.g. ${data.dir} in --log.path ${data.dir}/log, with the values of
+	// the flags. Undefined and cyclic references are errors.
+	ResolveRefs bool
+	// Name the multi-value flags of slices after the singular form of their
+	// field, e.g. --host a --host b for Hosts, since each value is a single
+	// element. Singulars, if set, gives the singulars of irregular plural
+	// names, e.g. children, keyed by the name of the field the flag would
+	// otherwise have. The other ones follow the usual English rules.
+	SingularizeSlices bool
+	Singulars         map[string]string
+	// ValueInterceptor, if set, is called with the name of the flag and each
+	// raw value before any other check or transform, e.g. to log or reject
+	// values centrally. The value it returns is used instead, and an error
+	// rejects the value.
+	ValueInterceptor func(name, rawValue string) (string, error)
+	// CompileTimeout, if positive, bounds the time spent compiling the value
+	// of a *regexp.Regexp field. The value is rejected if it takes longer.
+	CompileTimeout time.Duration
+	// Resolver looks up the hosts given to the flags with the resolvable
+	// option. It defaults to net.DefaultResolver.
+	Resolver HostResolver
+	// LookupTimeout bounds the time spent looking up a host given to a flag
+	// with the resolvable option. It defaults to DefaultLookupTimeout.
+	LookupTimeout time.Duration
+	// SkipLookups disables the resolvable option, e.g. in offline tests.
+	SkipLookups bool
+	// Stat looks up the paths given to the flags with the mustexist option.
+	// It defaults to os.Stat.
+	Stat func(name string) (os.FileInfo, error)
+	// SkipStats disables the mustexist option, e.g. in tests.
+	SkipStats bool
+	// DefaultFunc computes the defaults of flags, by flag name, from the
+	// object once its Defaults() methods were called, e.g. to default
+	// advertiseaddr to the value of bindaddr. It's only called if the field
+	// is zero, and again once parsed if the field wasn't overridden, so that
+	// the command line still wins.
+	DefaultFunc map[string]func(obj interface{}) string
+	// DefaultOrder lists flags of DefaultFunc whose defaults are computed
+	// first, in order, e.g. when a default depends on another computed one.
+	// The other defaults are computed next, in order of flag name. The
+	// functions are opaque, so their dependencies aren't checked for cycles:
+	// each default is computed once, from the values at that point.
+	DefaultOrder []string
+	// Warn when a flag which isn't defined matches an unexported field, which
+	// is skipped since it cannot be set.
+	WarnUnexported bool
+	// ErrorFormatter, if set, turns the errors of the values rejected by the
+	// flags into the errors returned, e.g. to localize them. It's given the
+	// name of the flag, the raw value, the kind of the field and the error.
+	// Otherwise the errors read e.g. invalid value "x" for flag -port: ...
+	ErrorFormatter func(name, value string, kind reflect.Kind, err error) error
+	// RevealSecrets renders the actual values of the fields with the secret
+	// option in ToArgs, ToEnviron, Describe and the errors of VerifyUnchanged,
+	// rather than masking them.
+	RevealSecrets bool
+	// DashAliases additionally defines a dash-joined alternative name for
+	// each dotted flag, e.g. -network-tcp-readtimeout for
+	// network.tcp.readtimeout, for legacy scripts. The dotted name remains
+	// the one described.
+	DashAliases bool
+	// StrictRepeats makes ParseArgs fail when a flag which isn't repeatable
+	// is given more than once, rather than letting the last value win. The
+	// flags of slices and maps, and the ones with the count option, are
+	// repeatable, see Repeatable.
+	StrictRepeats bool
+	// Logger, if set, is told about each flag whose field a successful parse
+	// changed, e.g. for audit trails. It's told once the Locker is unlocked,
+	// so that it can read the object through the same lock.
+	Logger Logger
+	// Locker, if set, is locked while the fields are being set, e.g. the
+	// write lock of the RWMutex guarding a config shared with readers, so that
+	// they don't see a partial update.
+	Locker sync.Locker
+	// UnsetToken, if set, is the value resetting a scalar field to the zero
+	// value of its type, or a pointer to nil, when given to its flag, e.g.
+	// --count=unset for "unset", to blank out a default without knowing its
+	// zero literal.
+	UnsetToken string
+	// ValueSeparator, if set, is accepted between the name and the value of
+	// a flag besides a space and =, e.g. ":" for --name:value, as pasted from
+	// tools with other conventions.
+	ValueSeparator string
+	// NormalizeSlices, if set, makes the empty slices backed by flags nil
+	// with NilSlices, or non-nil with EmptySlices, once parsed, whether they
+	// were given or kept their value, e.g. so that they're marshaled to JSON
+	// consistently as null or [].
+	NormalizeSlices SliceNormalization
+	// MaxArgs, if positive, is the maximum number of flags a parse accepts,
+	// e.g. to guard services taking overrides over the wire against huge
+	// argument lists. It counts the flags given, not their values, so that
+	// --ports 80,8000-8002 counts once.
+	MaxArgs int
+	// Warn is called with warnings about the flags being parsed, e.g. when a
+	// deprecated flag is set. If nil, warnings are printed to the output of
+	// the flag set, i.e. stderr.
+	Warn func(msg string)
+}
+
+// Logger is told about the overrides applied to the fields, see the Logger
+// option.
+type Logger interface {
+	// Applied is called with the values, as strings, of the field of the flag
+	// name before and after a parse which changed them. The values of the
+	// fields with the secret option are masked unless the RevealSecrets option
+	// is set.
+	Applied(name, oldValue, newValue string)
+}
+
+// HostResolver looks up the addresses of a host. *net.Resolver implements it.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
 }
 
+// NameStyle is the way the NameStyle option joins the words of field names.
+type NameStyle int
+
+const (
+	// DefaultStyle keeps field names as is, lower cased with UseLowerCase.
+	DefaultStyle NameStyle = iota
+	// KebabCase joins the words with dashes, e.g. db-name for DBName.
+	KebabCase
+	// SnakeCase joins the words with underscores, e.g. db_name for DBName.
+	SnakeCase
+)
+
+// SliceNormalization is the form of the empty slices for the NormalizeSlices
+// option.
+type SliceNormalization int
+
+const (
+	// KeepSlices leaves empty slices as they are, nil or not.
+	KeepSlices SliceNormalization = iota
+	// NilSlices makes empty slices nil.
+	NilSlices
+	// EmptySlices makes nil slices empty, non-nil ones.
+	EmptySlices
+)
+
+// DefaultLookupTimeout is the time spent looking up a host given to a flag
+// with the resolvable option, unless the LookupTimeout option is set.
+const DefaultLookupTimeout = 5 * time.Second
+
 // FlagMaker enumerate all the exported fields of a struct recursively
 // and create corresponding command line flags. For anonymous fields,
 // they are only enumerated if they are pointers to structs.
-// Usual GoLang flag rules apply, e.g. duplicated flag names leads to
-// panic.
+// Duplicated flag names make defining the flags fail with an error rather
+// than panic.
 type FlagMaker struct {
 	opts *FlagMakingOptions
 	// We don't consume os.Args directly unless told to.
 	fs *flag.FlagSet
+	// The object the flags are defined for.
+	obj interface{}
+	// The fields the flags are defined for, by flag name.
+	fields map[string]flagField
+	// The values of the fields set by their DefaultFunc, by flag name.
+	computed map[string]string
+	// Whether some flags have the bare confirm option, requiring --yes.
+	confirms bool
+	// The values of the fields before the current parse, by flag name.
+	saved map[string]savedField
+	// The values of the flags recorded by Freeze, by flag name.
+	frozen map[string]string
+	// The error returned by the ErrorFormatter option for the last value
+	// rejected during the current parse.
+	formatted error
+	// The flags with sources besides the arguments, in order of definition.
+	sources []sourceChain
+	// The fields with the catchall option.
+	catchalls []*catchall
+	// The slices with the grow option.
+	growables []*growable
+	// The values of the flags with the collect option.
+	collectors []namedCollector
+	// The names of the values of the enums registered, by enum type.
+	enums map[reflect.Type]map[string]int32
+	// The composite flags registered, in order of registration, and a
+	// shallow copy of the object before they were applied by the current
+	// parse, if they were, since they may set more than the fields of flags.
+	composites []composite
+	composed   reflect.Value
+	// The decoders registered with RegisterCodec, by type.
+	codecs map[reflect.Type]func(string, reflect.Value) error
+	// The finalizers registered with RegisterFinalizer, by struct type, the
+	// instances of these types in the object, in order of definition, and
+	// the elements of grown slices being defined.
+	finalizers map[reflect.Type]func(reflect.Value) error
+	instances  []instance
+	elems      []growElem
+	// The presets registered with RegisterProfile, by profile name.
+	profiles map[string]interface{}
+	// The flags the aliases loaded with LoadAliases stand for, by alias, and
+	// the aliases in order of loading.
+	aliases    map[string]string
+	aliasNames []string
+	// The flags standing for the alternative names of other flags, given in
+	// their flag tag, to the names of the flags.
+	alternates map[string]string
+	// The usage messages set with SetUsages, by flag name.
+	usages map[string]string
+	// The expressions registered with RedactMatching.
+	redactors []*regexp.Regexp
+	// The values allowed by SetAllowed, by flag name.
+	allowed map[string][]string
+	// The flag names, by dotted path of the fields.
+	byPath map[string]string
+	// The unexported fields skipped, by flag name, when warning about them.
+	unexported map[string]string
+	// The reasons the fields of unsupported types were skipped, by flag name.
+	unsupported map[string]string
+	// The transforms registered, by name.
+	transforms map[string]func(string) (string, error)
+	// The canonicalizers registered, by name.
+	canonicalizers map[string]func(string) string
+	// The flags set from positional arguments.
+	positionals []positional
+	// The sources of the values of the fields, by flag name, guarded by mu
+	// rather than the Locker option, and the sources recorded during the
+	// current parse, only touched by the parse.
+	mu         sync.Mutex
+	provenance map[string]string
+	setBy      map[string]string
+	// The first error met while defining the flags.
+	err error
+	// Called once the flags are parsed.
+	validators []func(obj interface{}) error
+	// Don't call Defaults() when walking the object, used by makers which
+	// only read the object.
+	skipDefaults bool
+}
+
+// flagField is a field a flag is defined for.
+type flagField struct {
+	value reflect.Value
+	// The names of the fields leading to the field.
+	path []string
+	opts tagOptions
+}
+
+// catchall is a map field receiving the undefined flags starting with prefix.
+type catchall struct {
+	prefix string
+	field  reflect.Value
+	// The value of the field before the current parse.
+	saved reflect.Value
+}
+
+// growable is a slice with the grow option, whose length a parse may change.
+type growable struct {
+	slice reflect.Value
+	// The slice before the current parse.
+	saved reflect.Value
+}
+
+// growElem is the element at index of a slice with the grow option.
+type growElem struct {
+	slice reflect.Value
+	index int
+}
+
+// instance is a struct of a type with a finalizer, along with the elements of
+// grown slices it's part of, which only exist once the slices grew to them.
+type instance struct {
+	value reflect.Value
+	elems []growElem
+}
+
+// exists tells whether the slices holding the instance grew to it, if any.
+func (inst instance) exists() bool {
+	for _, e := range inst.elems {
+		if e.slice.Len() <= e.index {
+			return false
+		}
+	}
+	return true
+}
+
+// namedCollector is the value of a flag with the collect option.
+type namedCollector struct {
+	name  string
+	value *collectValue
+}
+
+// composite is a flag applying several settings at once.
+type composite struct {
+	name  string
+	apply func(obj interface{}) error
+}
+
+// savedField is the value of a field before a parse.
+type savedField struct {
+	value reflect.Value
+	// Whether the field is a nil pointer which the parse may attach a value to.
+	detach bool
+	// The value of the flag, recorded for the Logger option.
+	str string
+}
+
+// positional is a flag set from the positional argument at index.
+type positional struct {
+	index int
+	name  string
 }
 
 // NewFlagMaker creates a default FlagMaker which creates namespaced flags
@@ -227,9 +902,75 @@ func NewFlagMaker() *FlagMaker {
 // NewFlagMakerAdv gives full control to create flags.
 func NewFlagMakerAdv(options *FlagMakingOptions) *FlagMaker {
 	return &FlagMaker{
-		opts: options,
-		fs:   flag.NewFlagSet("xFlags", flag.ContinueOnError),
+		opts:           options,
+		fs:             flag.NewFlagSet("xFlags", flag.ContinueOnError),
+		fields:         make(map[string]flagField),
+		byPath:         make(map[string]string),
+		usages:         make(map[string]string),
+		allowed:        make(map[string][]string),
+		profiles:       make(map[string]interface{}),
+		finalizers:     make(map[reflect.Type]func(reflect.Value) error),
+		codecs:         make(map[reflect.Type]func(string, reflect.Value) error),
+		aliases:        make(map[string]string),
+		alternates:     make(map[string]string),
+		enums:          make(map[reflect.Type]map[string]int32),
+		computed:       make(map[string]string),
+		unexported:     make(map[string]string),
+		unsupported:    make(map[string]string),
+		transforms:     make(map[string]func(string) (string, error)),
+		canonicalizers: make(map[string]func(string) string),
+	}
+}
+
+// inspector returns a FlagMaker with the same options, transforms and usage
+// messages as fm, to define flags for reading an object without affecting the
+// flags already defined by fm. Defaults() isn't called, so that the current
+// values of the object are left as is.
+func (fm *FlagMaker) inspector() *FlagMaker {
+	r := NewFlagMakerAdv(fm.opts)
+	r.skipDefaults = true
+	r.transforms = fm.transforms
+	r.canonicalizers = fm.canonicalizers
+	r.composites = fm.composites
+	r.enums = fm.enums
+	r.codecs = fm.codecs
+	r.usages = fm.usages
+	r.redactors = fm.redactors
+	return r
+}
+
+// inspect returns an inspector with the flags defined for a deep copy of obj,
+// so that the nil pointers to structs allocated to define the flags of their
+// fields are allocated in the copy, leaving obj untouched.
+func (fm *FlagMaker) inspect(obj interface{}) (*FlagMaker, error) {
+	r := fm.inspector()
+	if v := reflect.ValueOf(obj); v.IsValid() {
+		obj = deepCopy(v).Interface()
+	}
+	if err := r.defineFlags(obj); err != nil {
+		return nil, err
 	}
+	return r, nil
+}
+
+// like returns a FlagMaker with the same options, registrations, aliases and
+// validators as fm, on which no flags are defined yet.
+func (fm *FlagMaker) like() *FlagMaker {
+	r := NewFlagMakerAdv(fm.opts)
+	r.transforms = fm.transforms
+	r.canonicalizers = fm.canonicalizers
+	r.composites = fm.composites
+	r.enums = fm.enums
+	r.codecs = fm.codecs
+	r.profiles = fm.profiles
+	r.finalizers = fm.finalizers
+	r.aliases = fm.aliases
+	r.aliasNames = fm.aliasNames
+	r.usages = fm.usages
+	r.redactors = fm.redactors
+	r.allowed = fm.allowed
+	r.validators = fm.validators
+	return r
 }
 
 // ParseArgs parses the string arguments which should not contain the program name.
@@ -247,155 +988,2525 @@ func (fm *FlagMaker) PrintDefaults() {
 	fm.fs.PrintDefaults()
 }
 
+// RegisterInto defines the flags for obj on fs instead of the FlagSet of the
+// FlagMaker, so they can be parsed along with flags defined elsewhere with a
+// single fs.Parse call. A flag whose name is already defined on fs results in
+// an error. It must be called before the flags of the FlagMaker are defined,
+// and later calls to the FlagMaker with the same object use fs.
+func (fm *FlagMaker) RegisterInto(obj interface{}, fs *flag.FlagSet) error {
+	if fm.obj != nil {
+		return fmt.Errorf("flags are already defined")
+	}
+	// the flags are defined apart, so that fs is left as is on errors
+	saved := fm.fs
+	fm.fs = flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	if err := fm.defineFlags(obj); err != nil {
+		fm.fs = saved
+		return err
+	}
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) != nil && f.Name != confirmFlag && err == nil {
+			err = fmt.Errorf("flag %s is defined twice", f.Name)
+		}
+	})
+	if err != nil {
+		fm.fs, fm.obj = saved, nil
+		return err
+	}
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			// the confirm flag of the caller, if any, is used instead
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	fm.fs = fs
+	return nil
+}
+
 // ParseArgs parses the arguments based on the FlagMaker's setting.
 func (fm *FlagMaker) ParseArgs(obj interface{}, args []string) ([]string, error) {
-	v := reflect.ValueOf(obj)
-	if v.Kind() != reflect.Ptr {
-		return args, fmt.Errorf("top level object must be a pointer. %v is passed", v.Type())
+	_, left, err := fm.ParseArgsFS(obj, args)
+	return left, err
+}
+
+// ParseArgsFS is like ParseArgs but also returns the FlagSet the flags are
+// defined on, e.g. to call its Usage() when parsing fails.
+func (fm *FlagMaker) ParseArgsFS(obj interface{}, args []string) (*flag.FlagSet, []string, error) {
+	var changes []Change
+	// the Logger is told about the changes once unlocked, so that it can read
+	// the object through the lock
+	defer func() { fm.logChanges(changes) }()
+	defer fm.lock()()
+	if m, ok := mapTarget(obj); ok {
+		left, err := parseMapArgs(m, args)
+		if err != nil {
+			return fm.fs, left, err
+		}
+		for _, validate := range fm.validators {
+			if err := validate(obj); err != nil {
+				return fm.fs, left, err
+			}
+		}
+		return fm.fs, left, nil
 	}
-	if v.IsNil() {
-		return args, fmt.Errorf("top level object cannot be nil")
+	if err := fm.defineFlags(obj); err != nil {
+		return fm.fs, args, err
 	}
+	fm.warnUnexported(args)
+	fm.beginParse()
+	left, err := fm.parse(obj, args)
+	if err != nil {
+		fm.rollback()
+	} else {
+		changes = fm.applied()
+	}
+	return fm.fs, left, err
+}
 
-	switch e := v.Elem(); e.Kind() {
-	case reflect.Struct:
-		fm.enumerateAndCreate("", e)
-	case reflect.Interface:
-		if e.Elem().Kind() == reflect.Ptr {
-			fm.enumerateAndCreate("", e)
-		} else {
-			return args, fmt.Errorf("interface must have pointer underlying type. %v is passed", v.Type())
-		}
-	default:
-		return args, fmt.Errorf("object must be a pointer to struct or interface. %v is passed", v.Type())
+// ParseArgsCopy is like ParseArgs but leaves obj untouched: the arguments are
+// applied to a deep copy of obj, which is returned along with the arguments
+// left. Pointers, slices and maps are copied rather than shared, so that
+// later changes to either object don't show in the other one; obj must not
+// contain cycles. Each call defines the flags anew with the options and
+// registrations of the FlagMaker, so it can be called again, e.g. on every
+// reload, while the FlagMaker itself remains free for another object.
+func (fm *FlagMaker) ParseArgsCopy(obj interface{}, args []string) (interface{}, []string, error) {
+	if obj == nil {
+		return nil, args, fmt.Errorf("cannot parse into a copy of nil")
+	}
+	c := fm.lockedCopy(obj)
+	r := fm.like()
+	opts := *fm.opts
+	// the copy isn't shared with anyone
+	opts.Locker = nil
+	r.opts = &opts
+	left, err := r.ParseArgs(c, args)
+	if err != nil {
+		return nil, left, err
 	}
+	return c, left, nil
+}
 
-	err := fm.fs.Parse(args)
-	return fm.fs.Args(), err
+// Change is an override Preview reports, with the values of the field of the
+// flag as strings.
+type Change struct {
+	Name string
+	Old  string
+	New  string
 }
 
-func (fm *FlagMaker) enumerateAndCreate(prefix string, value reflect.Value) {
-	switch value.Kind() {
-	case
-		// do no create flag for these types
-		reflect.Map,
-		reflect.Uintptr,
-		reflect.UnsafePointer,
-		reflect.Array,
-		reflect.Chan,
-		reflect.Func:
-		return
-	case reflect.Slice:
-		// only support slice of strings, ints and float64s
-		switch value.Type().Elem().Kind() {
-		case reflect.String:
-			fm.defineStringSlice(prefix, value)
-		case reflect.Int:
-			fm.defineIntSlice(prefix, value)
-		case reflect.Float64:
-			fm.defineFloat64Slice(prefix, value)
+// changeLog records the changes reported to the Logger option.
+type changeLog []Change
+
+func (l *changeLog) Applied(name, oldValue, newValue string) {
+	*l = append(*l, Change{Name: name, Old: oldValue, New: newValue})
+}
+
+// Preview returns the changes parsing args into obj would make, in
+// lexicographical order of flag name, without changing obj: the arguments
+// are applied to a deep copy of obj, as with ParseArgsCopy. Flags set to the
+// value their field already has aren't reported, and the values of the
+// fields with the secret option are masked unless the RevealSecrets option is
+// set. If args are invalid, the error of the parse is returned.
+func (fm *FlagMaker) Preview(obj interface{}, args []string) ([]Change, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("cannot preview changes to nil")
+	}
+	var changes changeLog
+	r := fm.like()
+	opts := *fm.opts
+	// the copy isn't shared with anyone
+	opts.Locker = nil
+	opts.Logger = &changes
+	r.opts = &opts
+	if _, err := r.ParseArgs(fm.lockedCopy(obj), args); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// lockedCopy returns a deep copy of obj made while holding the Locker option,
+// so that it isn't torn by a concurrent update.
+func (fm *FlagMaker) lockedCopy(obj interface{}) interface{} {
+	defer fm.lock()()
+	return deepCopy(reflect.ValueOf(obj)).Interface()
+}
+
+// parse parses args, once the parse began, and checks the result.
+func (fm *FlagMaker) parse(obj interface{}, args []string) ([]string, error) {
+	if fm.opts.MaxArgs > 0 {
+		if err := fm.checkMaxArgs(args); err != nil {
+			return args, err
 		}
-		return
-	case
-		// Basic value types
-		reflect.String,
-		reflect.Bool,
-		reflect.Float32, reflect.Float64,
-		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		fm.defineFlag(prefix, value)
-		return
-	case reflect.Interface:
-		if !value.IsNil() {
-			fm.enumerateAndCreate(prefix, value.Elem())
+	}
+	args = fm.expandOptArgs(args)
+	args = fm.splitSeparators(args)
+	args, extra, err := fm.extractCatchalls(args)
+	if err != nil {
+		return args, err
+	}
+	if fm.opts.StrictRepeats {
+		if err := fm.checkRepeats(args); err != nil {
+			return args, err
 		}
-		return
-	case reflect.Ptr:
-		if value.IsNil() {
-			value.Set(reflect.New(value.Type().Elem()))
+	}
+	if name := fm.requestedProfile(args); len(name) > 0 {
+		if err := fm.applyProfile(obj, name); err != nil {
+			return args, fmt.Errorf("profile %s: %v", name, err)
 		}
-		fm.enumerateAndCreate(prefix, value.Elem())
-		return
-	case reflect.Struct:
-		// keep going
-	default:
-		panic(fmt.Sprintf("unknown reflected kind %v", value.Kind()))
 	}
+	for _, c := range fm.requestedComposites(args) {
+		if !fm.composed.IsValid() {
+			fm.composed = reflect.New(reflect.TypeOf(obj).Elem()).Elem()
+			fm.composed.Set(reflect.ValueOf(obj).Elem())
+		}
+		if err := c.apply(obj); err != nil {
+			return args, fmt.Errorf("composite flag %s: %v", c.name, err)
+		}
+	}
+	if err := fm.fs.Parse(args); err != nil {
+		if fm.formatted != nil {
+			// the flag package wraps the formatted error
+			return fm.fs.Args(), fm.formatted
+		}
+		return fm.fs.Args(), err
+	}
+	fm.setCatchalls(extra)
+	if err := fm.collectedErr(); err != nil {
+		return fm.fs.Args(), err
+	}
+	for name := range fm.scan(args) {
+		fm.record(name, "flag")
+	}
+	left, err := fm.setPositionals(fm.fs.Args())
+	if err != nil {
+		return left, err
+	}
+	if err := fm.applySources(args); err != nil {
+		return left, err
+	}
+	fm.endParse()
+	if err := fm.recomputeDefaults(); err != nil {
+		return left, err
+	}
+	if fm.opts.ResolveRefs {
+		if err := fm.resolveRefs(); err != nil {
+			return left, err
+		}
+	}
+	if err := fm.checkRequired(); err != nil {
+		return left, err
+	}
+	if err := fm.checkConfirmed(); err != nil {
+		return left, err
+	}
+	// the instances are recorded parents first, so children are finalized
+	// first
+	for i := len(fm.instances) - 1; i >= 0; i-- {
+		inst := fm.instances[i]
+		if !inst.exists() {
+			// the spare capacity of a grown slice
+			continue
+		}
+		if err := fm.finalizers[inst.value.Type()](inst.value); err != nil {
+			return left, fmt.Errorf("finalizing %v: %v", inst.value.Type(), err)
+		}
+	}
+	for _, validate := range fm.validators {
+		if err := validate(obj); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
 
-	numFields := value.NumField()
-	tt := value.Type()
-
-	for i := 0; i < numFields; i++ {
-		stField := tt.Field(i)
-		// Skip unexported fields, as only exported fields can be set. This is similar to how json and yaml work.
-		if stField.PkgPath != "" && !stField.Anonymous {
+// warnUnexported warns about the flags in args which are not defined but
+// match unexported fields, or fields of unexported struct fields.
+func (fm *FlagMaker) warnUnexported(args []string) {
+	if len(fm.unexported) == 0 {
+		return
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			return
+		}
+		if len(arg) < 2 || arg[0] != '-' {
 			continue
 		}
-		if stField.Anonymous && fm.getUnderlyingType(stField.Type).Kind() != reflect.Struct {
+		name := strings.TrimPrefix(arg[1:], "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		if fm.fs.Lookup(name) != nil {
 			continue
 		}
-		field := value.Field(i)
-		optName := fm.getName(stField)
-		if len(prefix) > 0 && !fm.opts.Flatten {
-			optName = prefix + "." + optName
+		for fieldName, field := range fm.unexported {
+			if name == fieldName || strings.HasPrefix(name, fieldName+".") {
+				fm.warn(fmt.Sprintf("flag %s matches the unexported field %s, which cannot be overridden", name, field))
+				break
+			}
 		}
-		fm.enumerateAndCreate(optName, field)
 	}
 }
 
-func (fm *FlagMaker) getName(field reflect.StructField) string {
-	name := field.Tag.Get(fm.opts.TagName)
-	if len(name) == 0 {
-		if field.Anonymous {
-			name = fm.getUnderlyingType(field.Type).Name()
-		} else {
-			name = field.Name
-		}
+// mapTarget returns the map obj points to, if obj is a non-nil pointer to a
+// map[string]string or a map[string]interface{}.
+func mapTarget(obj interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Map {
+		return reflect.Value{}, false
 	}
-	if fm.opts.UseLowerCase {
-		return strings.ToLower(name)
+	m := v.Elem()
+	if m.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, false
 	}
-	return name
+	switch elem := m.Type().Elem(); {
+	case elem.Kind() == reflect.String:
+	case elem.Kind() == reflect.Interface && elem.NumMethod() == 0:
+	default:
+		return reflect.Value{}, false
+	}
+	return m, true
 }
 
-func (fm *FlagMaker) getUnderlyingType(ttype reflect.Type) reflect.Type {
-	// this only deals with *T unnamed type, other unnamed types, e.g. []int, struct{}
-	// will return empty string.
-	if ttype.Kind() == reflect.Ptr {
-		return fm.getUnderlyingType(ttype.Elem())
+// parseMapArgs stores the flags in args into the map m, with the flag names
+// taken verbatim as keys, since there are no fields to define flags for. A
+// flag takes the following argument as its value unless it's given as
+// -name=value, or it's followed by another flag or nothing, in which case the
+// value is "true". The values are stored as strings. As with the flag
+// package, parsing stops at the first non-flag argument or after "--".
+func parseMapArgs(m reflect.Value, args []string) ([]string, error) {
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
 	}
-	return ttype
+	for len(args) > 0 {
+		arg := args[0]
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimPrefix(arg[1:], "-")
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		} else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			value, args = args[0], args[1:]
+		}
+		if len(name) == 0 || name[0] == '-' || name[0] == '=' {
+			return args, fmt.Errorf("bad flag syntax: %s", arg)
+		}
+		m.SetMapIndex(reflect.ValueOf(name).Convert(m.Type().Key()), reflect.ValueOf(value).Convert(m.Type().Elem()))
+	}
+	return args, nil
 }
 
-// Each object has its type (which prescribes the possible operations/methods
-// could be invoked; it also has an underlying 'kind', int, float, struct etc.
-// Since user can freely define types, one 'kind' of object may correpond to
-// many types. We cannot do type assertion because types of same kind are still
-// different types. Instead, we convert to the primitive types that corresponds
-// to the kinds and create flag vars. One thing to know is that, the whole point
-// of defineFlag() method is to define flag.Vars that points to certain field
-// of the struct so that command line values can modify the struct. We cannot
-// define a flag var pointing to arbitrary 'free' varible.
+// kindTypes are the types of the values stored by BindSyncMap, by kind.
+var kindTypes = map[reflect.Kind]reflect.Type{
+	reflect.String:  reflect.TypeOf(""),
+	reflect.Bool:    reflect.TypeOf(false),
+	reflect.Int:     reflect.TypeOf(0),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+}
 
-// I wish GoLang had macro...
-var (
-	stringPtrType  = reflect.TypeOf((*string)(nil))
-	boolPtrType    = reflect.TypeOf((*bool)(nil))
-	float32PtrType = reflect.TypeOf((*float32)(nil))
-	float64PtrType = reflect.TypeOf((*float64)(nil))
-	intPtrType     = reflect.TypeOf((*int)(nil))
-	int8PtrType    = reflect.TypeOf((*int8)(nil))
-	int16PtrType   = reflect.TypeOf((*int16)(nil))
-	int32PtrType   = reflect.TypeOf((*int32)(nil))
-	int64PtrType   = reflect.TypeOf((*int64)(nil))
-	uintPtrType    = reflect.TypeOf((*uint)(nil))
-	uint8PtrType   = reflect.TypeOf((*uint8)(nil))
-	uint16PtrType  = reflect.TypeOf((*uint16)(nil))
-	uint32PtrType  = reflect.TypeOf((*uint32)(nil))
-	uint64PtrType  = reflect.TypeOf((*uint64)(nil))
-)
+// BindSyncMap parses args into m, a config store without a fixed struct. A
+// flag is defined for each key of schema, named after the key, and its values
+// are parsed as the kind given, e.g. reflect.Int stores ints. The defaults of
+// the flags are the values of m of the kind, so the flags given are the only
+// keys stored, and m is left unchanged if parsing fails. Only the basic kinds
+// are supported. As with ParseArgs, the arguments left after the flags are
+// returned.
+func (fm *FlagMaker) BindSyncMap(m *sync.Map, schema map[string]reflect.Kind, args []string) ([]string, error) {
+	r := NewFlagMakerAdv(fm.opts)
+	r.fs.SetOutput(fm.fs.Output())
+	values := make(map[string]reflect.Value, len(schema))
+	for key, kind := range schema {
+		t, ok := kindTypes[kind]
+		if !ok {
+			return args, fmt.Errorf("kind %v of key %s is not supported", kind, key)
+		}
+		v := reflect.New(t).Elem()
+		if cur, ok := m.Load(key); ok && reflect.TypeOf(cur) == t {
+			v.Set(reflect.ValueOf(cur))
+		}
+		values[key] = v
+		r.defineFlag(key, v, nil)
+	}
+	if err := r.fs.Parse(args); err != nil {
+		return r.fs.Args(), err
+	}
+	r.fs.Visit(func(f *flag.Flag) {
+		m.Store(f.Name, values[f.Name].Interface())
+	})
+	return r.fs.Args(), nil
+}
 
-func (fm *FlagMaker) defineFlag(name string, value reflect.Value) {
+// endParse post-processes the fields whose flags are set, once all the values
+// are applied.
+func (fm *FlagMaker) endParse() {
+	fm.fs.Visit(func(f *flag.Flag) {
+		field, ok := fm.fields[fm.canonicalName(f.Name)]
+		if !ok {
+			return
+		}
+		if _, ok := baseValue(f.Value).(multiValue); ok {
+			if field.opts.has("sort") {
+				sortSlice(field.value)
+			}
+			if field.opts.has("dedup") {
+				dedupSlice(field.value)
+			}
+		}
+	})
+	if fm.opts.NormalizeSlices != KeepSlices {
+		fm.normalizeSlices()
+	}
+}
+
+// normalizeSlices gives the empty slices backed by flags the form of the
+// NormalizeSlices option.
+func (fm *FlagMaker) normalizeSlices() {
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		field, ok := fm.fields[f.Name]
+		if !ok || field.value.Kind() != reflect.Slice || field.value.Len() > 0 {
+			return
+		}
+		if _, ok := baseValue(f.Value).(multiValue); !ok {
+			return
+		}
+		switch {
+		case fm.opts.NormalizeSlices == NilSlices && !field.value.IsNil():
+			field.value.Set(reflect.Zero(field.value.Type()))
+		case fm.opts.NormalizeSlices == EmptySlices && field.value.IsNil():
+			field.value.Set(reflect.MakeSlice(field.value.Type(), 0, 0))
+		}
+	})
+}
+
+// refPattern matches the references to other flags in string fields.
+var refPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// resolveRefs substitutes the references to other flags in the string fields,
+// e.g. ${data.dir}, with the values of the flags, resolving the references of
+// the referenced fields first.
+func (fm *FlagMaker) resolveRefs() error {
+	const (
+		resolving = 1
+		resolved  = 2
+	)
+	state := make(map[string]int)
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		switch state[name] {
+		case resolving:
+			return fmt.Errorf("cyclic reference to flag %s", name)
+		case resolved:
+			return nil
+		}
+		field := fm.fields[name]
+		if field.value.Kind() != reflect.String {
+			state[name] = resolved
+			return nil
+		}
+		state[name] = resolving
+		var err error
+		val := refPattern.ReplaceAllStringFunc(field.value.String(), func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			ref = refPattern.FindStringSubmatch(ref)[1]
+			f := fm.fs.Lookup(ref)
+			if f == nil {
+				err = fmt.Errorf("flag %s references undefined flag %s", name, ref)
+				return ""
+			}
+			if err = resolve(ref); err != nil {
+				return ""
+			}
+			return f.Value.String()
+		})
+		if err != nil {
+			return err
+		}
+		field.value.SetString(val)
+		state[name] = resolved
+		return nil
+	}
+
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := fm.fields[f.Name]; ok && err == nil {
+			err = resolve(f.Name)
+		}
+	})
+	return err
+}
+
+// checkRequired returns an error if a required flag isn't set, by this parse
+// or a previous one. A flag with the required option is always required, one
+// with the requiredif option, e.g. `flag:",requiredif=mode=prod"`, is only
+// required if a field of the same struct, designated by its name, has the
+// given value once parsed.
+func (fm *FlagMaker) checkRequired() error {
+	set := make(map[string]bool)
+	fm.fs.Visit(func(f *flag.Flag) {
+		set[fm.canonicalName(f.Name)] = true
+	})
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		field, ok := fm.fields[f.Name]
+		if err != nil || !ok || set[f.Name] {
+			return
+		}
+		if field.opts.has("required") {
+			err = fmt.Errorf("flag %s is required", f.Name)
+			return
+		}
+		if !field.opts.has("requiredif") {
+			return
+		}
+		sibling, value, holds, cerr := fm.condition(f.Name, "requiredif")
+		switch {
+		case cerr != nil:
+			err = cerr
+		case holds:
+			err = fmt.Errorf("flag %s is required when %s is %s", f.Name, sibling.Name, value)
+		}
+	})
+	return err
+}
+
+// condition evaluates the condition given by the option of the flag name, e.g.
+// `flag:",requiredif=mode=tls"`, which holds if the sibling field it names has
+// the value it gives. It returns the flag of the sibling field and the value.
+func (fm *FlagMaker) condition(name, option string) (*flag.Flag, string, bool, error) {
+	field := fm.fields[name]
+	cond := strings.SplitN(field.opts.get(option, ""), "=", 2)
+	if len(cond) != 2 {
+		return nil, "", false, fmt.Errorf("invalid %s option %q for flag %s", option, field.opts.get(option, ""), name)
+	}
+	siblingPath := append(field.path[:len(field.path)-1:len(field.path)-1], cond[0])
+	sibling := fm.fs.Lookup(fm.byPath[strings.Join(siblingPath, ".")])
+	if sibling == nil {
+		return nil, "", false, fmt.Errorf("unknown field %s in %s option of flag %s", cond[0], option, name)
+	}
+	return sibling, cond[1], sibling.Value.String() == cond[1], nil
+}
+
+// collectedErr returns an error listing all the values rejected by the flags
+// with the collect option, if any.
+func (fm *FlagMaker) collectedErr() error {
+	var msgs []string
+	for _, c := range fm.collectors {
+		if len(c.value.errs) > 0 {
+			msgs = append(msgs, fmt.Sprintf("invalid values for flag %s: %s", c.name, strings.Join(c.value.errs, "; ")))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
+// confirmFlag is the flag confirming the flags with the bare confirm option.
+const confirmFlag = "yes"
+
+// checkConfirmed returns an error if a bool flag with the bare confirm option
+// is set to true without --yes.
+func (fm *FlagMaker) checkConfirmed() error {
+	if !fm.confirms {
+		return nil
+	}
+	if yes := fm.fs.Lookup(confirmFlag); yes != nil && yes.Value.String() == "true" {
+		return nil
+	}
+	var err error
+	fm.fs.Visit(func(f *flag.Flag) {
+		field, ok := fm.fields[fm.canonicalName(f.Name)]
+		if err != nil || !ok || !field.opts.has("confirm") || len(field.opts.get("confirm", "")) > 0 {
+			return
+		}
+		if f.Value.String() == "true" {
+			err = fmt.Errorf("flag %s requires --%s to be enabled", f.Name, confirmFlag)
+		}
+	})
+	return err
+}
+
+// setPositionals sets the flags of the fields with the positional option from
+// the arguments left after parsing the flags, and returns the arguments which
+// were not consumed.
+func (fm *FlagMaker) setPositionals(args []string) ([]string, error) {
+	if len(fm.positionals) == 0 {
+		return args, nil
+	}
+	used := make(map[int]bool)
+	for _, p := range fm.positionals {
+		if p.index >= len(args) {
+			continue
+		}
+		if err := fm.fs.Set(p.name, args[p.index]); err != nil {
+			return args, fmt.Errorf("invalid value %q for positional argument %d: %v", args[p.index], p.index, err)
+		}
+		fm.record(p.name, "flag")
+		used[p.index] = true
+	}
+	var left []string
+	for i, arg := range args {
+		if !used[i] {
+			left = append(left, arg)
+		}
+	}
+	return left, nil
+}
+
+// beginParse resets the state the flag values keep during a parse, and
+// records the values of the fields so that a failed parse can be rolled back.
+func (fm *FlagMaker) beginParse() {
+	fm.formatted = nil
+	fm.composed = reflect.Value{}
+	fm.saved = make(map[string]savedField, len(fm.fields))
+	fm.setBy = make(map[string]string)
+	for _, c := range fm.catchalls {
+		c.saved = reflect.Value{}
+	}
+	for _, g := range fm.growables {
+		g.saved = reflect.ValueOf(g.slice.Interface())
+	}
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if r, ok := f.Value.(resetter); ok {
+			r.reset()
+		}
+		if field, ok := fm.fields[f.Name]; ok {
+			l, lazy := f.Value.(*lazyValue)
+			saved := savedField{
+				value:  copyValue(field.value),
+				detach: lazy && l.isNil(),
+			}
+			if fm.opts.Logger != nil {
+				saved.str = f.Value.String()
+			}
+			fm.saved[f.Name] = saved
+		}
+	})
+}
+
+// lock locks the Locker option, if any, and returns the function unlocking it.
+func (fm *FlagMaker) lock() func() {
+	if fm.opts.Locker == nil {
+		return func() {}
+	}
+	fm.opts.Locker.Lock()
+	return fm.opts.Locker.Unlock
+}
+
+// record records that the field of the flag name was set from source during
+// the current parse.
+func (fm *FlagMaker) record(name, source string) {
+	fm.setBy[fm.canonicalName(name)] = source
+}
+
+// Provenance returns the source of the value of the field of each flag, by
+// flag name, as of the last successful parse, see the package documentation.
+// It's guarded by a mutex of its own, so it can be called during a parse,
+// e.g. while the Locker option is held or while the FlagSet given to
+// RegisterInto parses, which doesn't change it.
+func (fm *FlagMaker) Provenance() map[string]string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	provenance := make(map[string]string, len(fm.provenance))
+	for name, source := range fm.provenance {
+		provenance[name] = source
+	}
+	return provenance
+}
+
+// applied records the sources of the fields set during the parse, and
+// returns the changes of the flags whose fields changed, in lexicographical
+// order, for the Logger option, if set.
+func (fm *FlagMaker) applied() []Change {
+	fm.mu.Lock()
+	for name, source := range fm.setBy {
+		fm.provenance[name] = source
+	}
+	fm.mu.Unlock()
+	if fm.opts.Logger == nil {
+		return nil
+	}
+	var changes []Change
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		saved, ok := fm.saved[f.Name]
+		if !ok {
+			return
+		}
+		if val := f.Value.String(); val != saved.str {
+			if fm.masked(f.Name) {
+				changes = append(changes, Change{Name: f.Name, Old: secretMask, New: secretMask})
+			} else {
+				changes = append(changes, Change{Name: f.Name, Old: saved.str, New: val})
+			}
+		}
+	})
+	return changes
+}
+
+// logChanges tells the Logger option, if set, about changes.
+func (fm *FlagMaker) logChanges(changes []Change) {
+	for _, c := range changes {
+		fm.opts.Logger.Applied(c.Name, c.Old, c.New)
+	}
+}
+
+// rollback restores the fields to their values before the parse, so that a
+// failed parse leaves them all unchanged.
+func (fm *FlagMaker) rollback() {
+	if fm.composed.IsValid() {
+		// first undo the composite flags, e.g. pointers they replaced, then
+		// restore the fields of the flags as usual
+		reflect.ValueOf(fm.obj).Elem().Set(fm.composed)
+	}
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		saved, ok := fm.saved[f.Name]
+		if !ok {
+			return
+		}
+		fm.fields[f.Name].value.Set(saved.value)
+		if l, ok := f.Value.(*lazyValue); ok {
+			if saved.detach {
+				l.detach()
+			} else {
+				// e.g. unset by the parse
+				l.attach()
+			}
+		}
+	})
+	for _, c := range fm.catchalls {
+		if c.saved.IsValid() {
+			c.field.Set(c.saved)
+		}
+	}
+	for _, g := range fm.growables {
+		g.slice.Set(g.saved)
+	}
+}
+
+// AddCrossValidator registers a function validating constraints spanning
+// several fields, e.g. MinConns <= MaxConns. Validators are called in order of
+// registration with the populated object once ParseArgs applied all the flags,
+// and the first error returned fails ParseArgs.
+func (fm *FlagMaker) AddCrossValidator(validate func(obj interface{}) error) {
+	fm.validators = append(fm.validators, validate)
+}
+
+// defineFlags creates the flags for obj. Flags are created only once, so that
+// obj can be overridden from several sources using the same FlagMaker.
+func (fm *FlagMaker) defineFlags(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("top level object must be a pointer. %v is passed", v.Type())
+	}
+	if v.IsNil() {
+		return fmt.Errorf("top level object cannot be nil")
+	}
+	if fm.obj != nil {
+		if fm.obj != obj {
+			return fmt.Errorf("flags are already defined for another object")
+		}
+		return nil
+	}
+
+	switch e := v.Elem(); e.Kind() {
+	case reflect.Struct:
+		fm.enumerateAndCreate("", nil, e, nil)
+	case reflect.Interface:
+		if e.Elem().Kind() == reflect.Ptr {
+			fm.enumerateAndCreate("", nil, e, nil)
+		} else {
+			return fmt.Errorf("interface must have pointer underlying type. %v is passed", v.Type())
+		}
+	default:
+		return fmt.Errorf("object must be a pointer to struct or interface. %v is passed", v.Type())
+	}
+	if fm.opts.DashAliases {
+		fm.defineDashAliases()
+	}
+	if fm.err != nil {
+		return fm.err
+	}
+	for _, c := range fm.composites {
+		if fm.fs.Lookup(c.name) != nil {
+			return fmt.Errorf("composite flag %s is already defined", c.name)
+		}
+		fm.fs.Bool(c.name, false, "composite flag")
+	}
+	for _, alias := range fm.aliasNames {
+		if err := fm.defineAlias(alias, fm.aliases[alias]); err != nil {
+			return err
+		}
+	}
+	if len(fm.profiles) > 0 {
+		if fm.fs.Lookup(profileFlag) != nil {
+			return fmt.Errorf("flag %s is already defined", profileFlag)
+		}
+		fm.fs.Var(newProfileValue(fm.profiles), profileFlag, "preset applied before the other flags")
+	}
+	if fm.confirms && fm.fs.Lookup(confirmFlag) == nil {
+		fm.fs.Bool(confirmFlag, false, "confirm enabling the flags requiring confirmation")
+	}
+	if !fm.skipDefaults {
+		if err := fm.computeDefaults(obj); err != nil {
+			return err
+		}
+	}
+	fm.mu.Lock()
+	fm.provenance = make(map[string]string, len(fm.fields))
+	for name := range fm.fields {
+		fm.provenance[name] = "default"
+	}
+	fm.mu.Unlock()
+	fm.obj = obj
+	return nil
+}
+
+// defaultOrder returns the names of the flags which have a DefaultFunc, in
+// the order the defaults are computed: the ones of the DefaultOrder option
+// first, then the other ones in order of flag name.
+func (fm *FlagMaker) defaultOrder() ([]string, error) {
+	names := make([]string, 0, len(fm.opts.DefaultFunc))
+	seen := make(map[string]bool, len(fm.opts.DefaultFunc))
+	for _, name := range fm.opts.DefaultOrder {
+		if _, ok := fm.opts.DefaultFunc[name]; !ok {
+			return nil, fmt.Errorf("no default function for flag %s in default order", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("flag %s is listed twice in DefaultOrder", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	var rest []string
+	for name := range fm.opts.DefaultFunc {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...), nil
+}
+
+// computeDefaults sets the zero fields which have a DefaultFunc to the value
+// it computes from obj, in the default order. The value becomes the default
+// of the flag, so it's not considered set.
+func (fm *FlagMaker) computeDefaults(obj interface{}) error {
+	names, err := fm.defaultOrder()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		f := fm.fs.Lookup(name)
+		field, ok := fm.fields[name]
+		if f == nil || !ok {
+			return fmt.Errorf("default function given for unknown flag %s", name)
+		}
+		if !field.value.IsZero() {
+			continue
+		}
+		val := fm.opts.DefaultFunc[name](obj)
+		if err := setDefault(f, val); err != nil {
+			return fmt.Errorf("invalid default %q for flag %s: %v", val, name, err)
+		}
+		f.DefValue = f.Value.String()
+		fm.computed[name] = f.DefValue
+	}
+	return nil
+}
+
+// recomputeDefaults computes again the defaults of the fields which weren't
+// set by the parse and still have their computed default, in the default
+// order, so that they depend on the parsed values of the other fields.
+func (fm *FlagMaker) recomputeDefaults() error {
+	if len(fm.computed) == 0 {
+		return nil
+	}
+	names, err := fm.defaultOrder()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		f := fm.fs.Lookup(name)
+		computed, ok := fm.computed[name]
+		if _, set := fm.setBy[name]; !ok || set || f.Value.String() != computed {
+			continue
+		}
+		val := fm.opts.DefaultFunc[name](fm.obj)
+		if err := setDefault(f, val); err != nil {
+			return fmt.Errorf("invalid default %q for flag %s: %v", val, name, err)
+		}
+		fm.computed[name] = f.Value.String()
+	}
+	return nil
+}
+
+// setDefault sets the flag f to the computed default val through its base
+// value, so that the steps meant for the values given by the user, e.g.
+// deprecation warnings, interceptors and confirmations, are skipped.
+func setDefault(f *flag.Flag, val string) error {
+	if err := baseValue(f.Value).Set(val); err != nil {
+		return err
+	}
+	if l, ok := f.Value.(*lazyValue); ok {
+		l.attach()
+	}
+	return nil
+}
+
+// enumerateAndCreate creates the flags for value, whose flag name is prefix.
+// path holds the names of the fields leading to value, which form the flag
+// name unless flags are flattened.
+func (fm *FlagMaker) enumerateAndCreate(prefix string, path []string, value reflect.Value, opts tagOptions) {
+	if kind := value.Kind(); opts.has("as") && !isBasicKind(kind) && kind != reflect.Ptr && kind != reflect.Interface {
+		fm.setErr(fmt.Errorf("as option is only supported for basic types, not for flag %s", prefix))
+		return
+	}
+	if decode, ok := fm.codecs[value.Type()]; ok {
+		if !fm.checkName(prefix) {
+			return
+		}
+		fm.fs.Var(newCodecValue(value, decode), prefix, prefix)
+		fm.finishFlag(prefix, path, value, opts)
+		return
+	}
+	switch value.Kind() {
+	case reflect.Map:
+		if opts.has("catchall") {
+			fm.defineCatchall(prefix, value, opts.get("catchall", ""))
+			return
+		}
+		// only support maps of bools and strings
+		isBoolMap := value.Type().ConvertibleTo(boolMapType)
+		if !isBoolMap && !value.Type().ConvertibleTo(stringMapType) {
+			fm.skipUnsupported(prefix, value)
+			return
+		}
+		if !fm.checkName(prefix) {
+			return
+		}
+		if isBoolMap {
+			fm.defineBoolMap(prefix, value, opts)
+		} else {
+			fm.defineStringMap(prefix, value, opts)
+		}
+		fm.finishFlag(prefix, path, value, opts)
+		return
+	case
+		// do no create flag for these types
+		reflect.Uintptr,
+		reflect.UnsafePointer,
+		reflect.Complex64, reflect.Complex128,
+		reflect.Array,
+		reflect.Chan,
+		reflect.Func:
+		fm.skipUnsupported(prefix, value)
+		return
+	case reflect.Slice:
+		if elem := value.Type().Elem(); elem.Kind() == reflect.Struct && elem != timeType {
+			fm.defineStructSlice(prefix, path, value, opts)
+			return
+		}
+		if !fm.checkName(prefix) {
+			return
+		}
+		// only support MAC addresses and slice of strings, ints, float64s,
+		// bools, time.Times, runes, IPs and string maps
+		switch {
+		case value.Type() == hardwareAddrType:
+			fm.defineHardwareAddr(prefix, value)
+		case value.Type() == mapSliceType:
+			fm.defineMapSlice(prefix, value)
+		case value.Type().Elem() == ipType:
+			fm.defineIPSlice(prefix, value)
+		case value.Type().Elem() == timeType:
+			fm.defineTimeSlice(prefix, value, opts)
+		case value.Type().Elem() == runeType && opts.has("runes"):
+			fm.defineRuneSlice(prefix, value)
+		case value.Type().Elem().Kind() == reflect.String:
+			fm.defineStringSlice(prefix, value)
+		case value.Type().Elem().Kind() == reflect.Int:
+			fm.defineIntSlice(prefix, value)
+		case value.Type().Elem().Kind() == reflect.Float64:
+			fm.defineFloat64Slice(prefix, value)
+		case value.Type().Elem().Kind() == reflect.Bool:
+			fm.defineBoolSlice(prefix, value)
+		default:
+			fm.skipUnsupported(prefix, value)
+			return
+		}
+		fm.finishFlag(prefix, path, value, opts)
+		return
+	case
+		// Basic value types
+		reflect.String,
+		reflect.Bool,
+		reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !fm.checkName(prefix) {
+			return
+		}
+		switch {
+		case opts.has("as"):
+			hinted, ok := fm.hintedValue(prefix, value, opts.get("as", ""))
+			if !ok {
+				return
+			}
+			fm.defineFlag(prefix, hinted, opts)
+		case opts.has("count"):
+			if !value.CanInt() {
+				fm.setErr(fmt.Errorf("count option is only supported for signed integers, not for flag %s", prefix))
+				return
+			}
+			fm.fs.Var(newCountValue(value), prefix, prefix)
+		case fm.enums[value.Type()] != nil && value.CanInt():
+			fm.fs.Var(newEnumValue(value, fm.enums[value.Type()]), prefix, prefix)
+		case value.Type() == jsonNumberType:
+			fm.defineJSONNumber(prefix, value)
+		default:
+			fm.defineFlag(prefix, value, opts)
+		}
+		fm.finishFlag(prefix, path, value, opts)
+		return
+	case reflect.Interface:
+		if !value.IsNil() {
+			fm.enumerateAndCreate(prefix, path, value.Elem(), opts)
+		}
+		return
+	case reflect.Ptr:
+		if value.Type() == ratPtrType {
+			if !fm.checkName(prefix) {
+				return
+			}
+			fm.defineRat(prefix, value)
+			fm.finishFlag(prefix, path, value, opts)
+			return
+		}
+		if value.Type() == regexpPtrType {
+			if !fm.checkName(prefix) {
+				return
+			}
+			fm.defineRegexp(prefix, value)
+			fm.finishFlag(prefix, path, value, opts)
+			return
+		}
+		if value.Type() == locationPtrType {
+			if !fm.checkName(prefix) {
+				return
+			}
+			fm.defineLocation(prefix, value)
+			fm.finishFlag(prefix, path, value, opts)
+			return
+		}
+		if (value.IsNil() || len(fm.opts.UnsetToken) > 0) && fm.getUnderlyingType(value.Type()).Kind() != reflect.Struct {
+			// Optional values stay nil unless they're set, so the flag is
+			// defined on a detached value which is attached when set. With
+			// the UnsetToken option, the values already set are defined the
+			// same way, so that they can be detached again.
+			target := reflect.New(value.Type().Elem())
+			if !value.IsNil() {
+				target = reflect.ValueOf(value.Interface())
+			}
+			existing := fm.fs.Lookup(prefix)
+			fm.enumerateAndCreate(prefix, path, target.Elem(), opts)
+			if f := fm.fs.Lookup(prefix); f != nil && f != existing {
+				f.Value = newLazyValue(f.Value.(flag.Getter), value, target)
+			}
+			return
+		}
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		fm.enumerateAndCreate(prefix, path, value.Elem(), opts)
+		return
+	case reflect.Struct:
+		if value.Type() == rgbaType {
+			if !fm.checkName(prefix) {
+				return
+			}
+			fm.defineRGBA(prefix, value)
+			fm.finishFlag(prefix, path, value, opts)
+			return
+		}
+		// keep going
+		fm.callDefaults(value)
+		if _, ok := fm.finalizers[value.Type()]; ok {
+			elems := append([]growElem(nil), fm.elems...)
+			fm.instances = append(fm.instances, instance{value: value, elems: elems})
+		}
+	default:
+		fm.skipUnsupported(prefix, value)
+		return
+	}
+
+	numFields := value.NumField()
+	tt := value.Type()
+
+	for i := 0; i < numFields; i++ {
+		stField := tt.Field(i)
+		if stField.Anonymous && fm.getUnderlyingType(stField.Type).Kind() != reflect.Struct {
+			continue
+		}
+		field := value.Field(i)
+		name := fm.getName(stField)
+		alternates := fm.alternateNames(stField)
+		if fm.opts.SingularizeSlices && fm.isSliceFlag(stField.Type) {
+			name = fm.singular(name)
+			for j, alt := range alternates {
+				alternates[j] = fm.singular(alt)
+			}
+		}
+		_, fieldOpts := parseFlagTag(stField.Tag.Get(flagTagName))
+		// the group of a struct applies to its fields without a group
+		if group := stField.Tag.Get(groupTagName); len(group) > 0 {
+			fieldOpts["group"] = group
+		} else if opts.has("group") {
+			fieldOpts["group"] = opts.get("group", "")
+		}
+		// the field of a collapsed embedded struct is named as if it were a
+		// field of the parent struct
+		collapsed := stField.Anonymous && fm.opts.CollapseSingles && fm.getUnderlyingType(stField.Type).NumField() == 1
+		fieldPath := path
+		if !collapsed {
+			// a dotted name, e.g. `json:"tcp.readtimeout"`, gives several
+			// elements of the path
+			fieldPath = append(path[:len(path):len(path)], strings.Split(name, ".")...)
+		}
+		flagName := func(fieldPath []string, name string) string {
+			switch {
+			case fm.opts.JSONPointer:
+				return jsonPointer(fieldPath)
+			case !fm.opts.Flatten || fieldOpts.has("keeppath"):
+				return strings.Join(fieldPath, ".")
+			case collapsed:
+				return prefix
+			default:
+				return name
+			}
+		}
+		optName := flagName(fieldPath, name)
+		// Skip unexported fields, as only exported fields can be set. This is similar to how json and yaml work.
+		// The fields of unexported embedded structs can be set, unless they're
+		// embedded through a pointer.
+		if stField.PkgPath != "" && (!stField.Anonymous || stField.Type.Kind() == reflect.Ptr) {
+			if fm.opts.WarnUnexported {
+				fm.unexported[optName] = tt.String() + "." + stField.Name
+			}
+			continue
+		}
+		if fm.opts.FieldFilter != nil && !fm.opts.FieldFilter(fieldPath, stField) {
+			continue
+		}
+		fm.enumerateAndCreate(optName, fieldPath, field, fieldOpts)
+		if chain := fm.sourceChain(optName, stField.Tag.Get(flagTagName)); len(chain) > 0 {
+			fm.sources = append(fm.sources, sourceChain{name: optName, sources: chain})
+		}
+		for _, alt := range alternates {
+			fm.defineAlternate(flagName(append(path[:len(path):len(path)], alt), alt), optName)
+		}
+	}
+}
+
+// source is a source of the value of a flag which isn't given, e.g. an
+// environment variable for `flag:",env=MYAPP_X"`.
+type source struct {
+	kind string // source, env, file or default
+	arg  string
+}
+
+// sourceChain are the sources of the value of a flag, in order.
+type sourceChain struct {
+	name    string
+	sources []source
+}
+
+// sourceChain returns the sources given in the flag tag of the flag name, in
+// the order of the tag, which is lost by tagOptions. The arguments, i.e.
+// source=flag, come first unless they're placed otherwise.
+func (fm *FlagMaker) sourceChain(name, tag string) []source {
+	var chain []source
+	hasArgs := false
+	for _, part := range strings.Split(tag, ",")[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		switch kv[0] {
+		case "source", "env", "file", "default":
+		default:
+			continue
+		}
+		if len(kv) != 2 || (kv[0] == "source" && kv[1] != "flag") {
+			fm.setErr(fmt.Errorf("invalid source %q for flag %s", part, name))
+			return nil
+		}
+		chain = append(chain, source{kind: kv[0], arg: kv[1]})
+		hasArgs = hasArgs || kv[0] == "source"
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	if fm.fs.Lookup(name) == nil && fm.err == nil {
+		fm.setErr(fmt.Errorf("sources are not supported for flag %s", name))
+	}
+	if !hasArgs {
+		// the arguments come first unless placed otherwise
+		chain = append([]source{{kind: "source", arg: "flag"}}, chain...)
+	}
+	return chain
+}
+
+// applySources sets the flags which have other sources than the arguments from
+// the first of their sources which has a value, which may be the arguments
+// args.
+func (fm *FlagMaker) applySources(args []string) error {
+	if len(fm.sources) == 0 {
+		return nil
+	}
+	// fm.fs also reports the flags set by the previous parses
+	given := make(map[string]bool)
+	for name := range fm.scan(args) {
+		given[fm.canonicalName(name)] = true
+	}
+	for _, c := range fm.sources {
+		for _, s := range c.sources {
+			val, ok, err := s.lookup(given[c.name])
+			if err != nil {
+				return fmt.Errorf("%s source of flag %s: %v", s.kind, c.name, err)
+			}
+			if !ok {
+				continue
+			}
+			if s.kind != "source" {
+				if err := fm.fs.Set(c.name, val); err != nil {
+					return fmt.Errorf("invalid value %q from %s source of flag %s: %v", val, s.kind, c.name, err)
+				}
+				fm.record(c.name, s.kind)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the source, if it has one. given tells whether
+// the flag was given in the arguments.
+func (s source) lookup(given bool) (string, bool, error) {
+	switch s.kind {
+	case "source":
+		return "", given, nil
+	case "env":
+		val, ok := os.LookupEnv(s.arg)
+		return val, ok, nil
+	case "file":
+		b, err := os.ReadFile(s.arg)
+		if os.IsNotExist(err) {
+			return "", false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	default:
+		return s.arg, true, nil
+	}
+}
+
+// defineAlternate defines the alternative name alt of the flag name, which
+// sets the same field without a warning.
+func (fm *FlagMaker) defineAlternate(alt, name string) {
+	f := fm.fs.Lookup(name)
+	if f == nil {
+		if fm.err == nil {
+			fm.setErr(fmt.Errorf("alternative names are not supported for flag %s", name))
+		}
+		return
+	}
+	if !fm.checkName(alt) {
+		return
+	}
+	fm.fs.Var(newAliasValue(f.Value.(flag.Getter), func() {}), alt, "alternative name of "+name)
+	fm.alternates[alt] = name
+}
+
+// defineDashAliases defines the dash-joined alternative name of each dotted
+// flag, e.g. network-tcp-readtimeout for network.tcp.readtimeout.
+func (fm *FlagMaker) defineDashAliases() {
+	var names []string
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := fm.alternates[f.Name]; !ok && strings.Contains(f.Name, ".") {
+			names = append(names, f.Name)
+		}
+	})
+	for _, name := range names {
+		fm.defineAlternate(strings.ReplaceAll(name, ".", "-"), name)
+	}
+}
+
+// defineStructSlice creates the flags for the fields of each element of a
+// slice of structs, whose flag names are prefixed by the index of the element,
+// e.g. workers.3.name. With the grow option, the flags are created for the
+// indices up to the maxlen option, and the slice grows up to the element
+// whose flag is set, the intermediate elements being zero.
+func (fm *FlagMaker) defineStructSlice(prefix string, path []string, value reflect.Value, opts tagOptions) {
+	grow := opts.has("grow")
+	n := value.Len()
+	if grow {
+		maxLen, err := strconv.Atoi(opts.get("maxlen", ""))
+		if err != nil || maxLen < 0 {
+			fm.setErr(fmt.Errorf("grow option requires a valid maxlen option for flag %s", prefix))
+			return
+		}
+		// the elements must not move once their flags are created, and the
+		// array of the slice, which may be shared, isn't written past its
+		// length, so the elements are copied to an array of their own
+		grown := reflect.MakeSlice(value.Type(), value.Len(), maxLen)
+		reflect.Copy(grown, value)
+		value.Set(grown)
+		fm.growables = append(fm.growables, &growable{slice: value})
+		if n < maxLen {
+			n = maxLen
+		}
+	}
+	elems := value.Slice(0, n)
+	for i := 0; i < n; i++ {
+		index := strconv.Itoa(i)
+		name := prefix + "." + index
+		if fm.opts.JSONPointer {
+			name = prefix + "/" + index
+		}
+		defined := make(map[string]bool)
+		fm.fs.VisitAll(func(f *flag.Flag) {
+			defined[f.Name] = true
+		})
+		if i >= value.Len() {
+			// elements the slice grows to are left zero
+			skip := fm.skipDefaults
+			fm.skipDefaults = true
+			fm.elems = append(fm.elems, growElem{slice: value, index: i})
+			fm.enumerateAndCreate(name, append(path[:len(path):len(path)], index), elems.Index(i), nil)
+			fm.elems = fm.elems[:len(fm.elems)-1]
+			fm.skipDefaults = skip
+		} else {
+			fm.enumerateAndCreate(name, append(path[:len(path):len(path)], index), elems.Index(i), nil)
+		}
+		if !grow {
+			continue
+		}
+		fm.fs.VisitAll(func(f *flag.Flag) {
+			if !defined[f.Name] {
+				f.Value = newGrowValue(f.Value.(flag.Getter), value, i)
+			}
+		})
+	}
+}
+
+// hintTypes are the types which can be given with the as option.
+var hintTypes = map[string]reflect.Type{
+	"string":   reflect.TypeOf(""),
+	"bool":     reflect.TypeOf(false),
+	"int":      reflect.TypeOf(int(0)),
+	"int8":     reflect.TypeOf(int8(0)),
+	"int16":    reflect.TypeOf(int16(0)),
+	"int32":    reflect.TypeOf(int32(0)),
+	"int64":    reflect.TypeOf(int64(0)),
+	"uint":     reflect.TypeOf(uint(0)),
+	"uint8":    reflect.TypeOf(uint8(0)),
+	"uint16":   reflect.TypeOf(uint16(0)),
+	"uint32":   reflect.TypeOf(uint32(0)),
+	"uint64":   reflect.TypeOf(uint64(0)),
+	"float32":  reflect.TypeOf(float32(0)),
+	"float64":  reflect.TypeOf(float64(0)),
+	"duration": durationType,
+}
+
+// hintedValue returns the field value as the type given by the as option,
+// e.g. `flag:",as=duration"` for type Timeout int64, so that the flag parses
+// its values as such. The type must have the same kind as the field.
+func (fm *FlagMaker) hintedValue(name string, value reflect.Value, hint string) (reflect.Value, bool) {
+	t, ok := hintTypes[hint]
+	if !ok {
+		fm.setErr(fmt.Errorf("unknown type %q in as option of flag %s", hint, name))
+		return value, false
+	}
+	if t.Kind() != value.Kind() {
+		fm.setErr(fmt.Errorf("type %s in as option is incompatible with the %v of flag %s", hint, value.Type(), name))
+		return value, false
+	}
+	return value.Addr().Convert(reflect.PtrTo(t)).Elem(), true
+}
+
+// skipUnsupported records that no flag is defined for the field of value,
+// since its type cannot be set from a flag.
+func (fm *FlagMaker) skipUnsupported(name string, value reflect.Value) {
+	fm.unsupported[name] = fmt.Sprintf("fields of type %v are not supported", value.Type())
+}
+
+// Unsupported returns the fields skipped when the flags were defined because
+// their type cannot be set from a flag, e.g. uintptr, channels or funcs, by
+// the name their flag would have, to the reason they were skipped.
+func (fm *FlagMaker) Unsupported() map[string]string {
+	unsupported := make(map[string]string, len(fm.unsupported))
+	for name, reason := range fm.unsupported {
+		unsupported[name] = reason
+	}
+	return unsupported
+}
+
+// defineCatchall records a map[string]string field receiving the undefined
+// flags starting with prefix, by name without the prefix.
+func (fm *FlagMaker) defineCatchall(name string, value reflect.Value, prefix string) {
+	if !value.Type().ConvertibleTo(stringMapType) {
+		fm.setErr(fmt.Errorf("catchall option is only supported for map[string]string, not for flag %s", name))
+		return
+	}
+	if len(prefix) == 0 {
+		fm.setErr(fmt.Errorf("catchall option of flag %s requires a prefix", name))
+		return
+	}
+	fm.catchalls = append(fm.catchalls, &catchall{prefix: prefix, field: value})
+}
+
+// argFlag is a flag given in the arguments of a parse.
+type argFlag struct {
+	index    int    // of the argument giving the flag
+	dashes   string // - or --
+	name     string
+	value    string
+	hasValue bool // given after = or the ValueSeparator, or as the next argument
+	sep      bool // given after the ValueSeparator
+	next     bool // given as the next argument
+}
+
+// argScanner walks the flags given in arguments the way the flag package
+// parses them, so that the passes over the arguments made before the parse
+// don't mistake values for flags. Like the flag package, it stops at the
+// first argument which isn't a flag, or at --. A flag given without a value
+// takes the next argument as its value unless it's a bool flag or has the
+// optarg option, or it's undefined and not for a catchall field.
+type argScanner struct {
+	fm   *FlagMaker
+	args []string
+	pos  int // of the next argument
+	flag argFlag
+}
+
+func (fm *FlagMaker) scanArgs(args []string) *argScanner {
+	return &argScanner{fm: fm, args: args}
+}
+
+// next moves to the next flag, which is then s.flag, and tells whether there
+// is one.
+func (s *argScanner) next() bool {
+	if s.pos >= len(s.args) {
+		return false
+	}
+	arg := s.args[s.pos]
+	if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+		return false
+	}
+	f := argFlag{index: s.pos, dashes: "-"}
+	if strings.HasPrefix(arg, "--") {
+		f.dashes = "--"
+	}
+	f.name = arg[len(f.dashes):]
+	s.pos++
+	if j := strings.Index(f.name, "="); j >= 0 {
+		f.name, f.value, f.hasValue = f.name[:j], f.name[j+1:], true
+	} else if j := s.fm.separatorIndex(f.name); j > 0 {
+		f.name, f.value, f.hasValue, f.sep = f.name[:j], f.name[j+len(s.fm.opts.ValueSeparator):], true, true
+	} else if s.fm.takesValue(f.name) && s.pos < len(s.args) {
+		f.value, f.hasValue, f.next = s.args[s.pos], true, true
+		s.pos++
+	}
+	s.flag = f
+	return true
+}
+
+// rest returns the arguments after the flags scanned so far.
+func (s *argScanner) rest() []string {
+	return s.args[s.pos:]
+}
+
+// separatorIndex returns the index of the ValueSeparator option in the name of
+// a flag given as name:value, e.g. --name:value, or -1.
+func (fm *FlagMaker) separatorIndex(name string) int {
+	sep := fm.opts.ValueSeparator
+	if len(sep) == 0 || fm.fs.Lookup(name) != nil {
+		return -1
+	}
+	if j := strings.Index(name, sep); j > 0 && fm.fs.Lookup(name[:j]) != nil {
+		return j
+	}
+	return -1
+}
+
+// takesValue tells whether the flag name given without a value takes the next
+// argument as its value.
+func (fm *FlagMaker) takesValue(name string) bool {
+	if f := fm.fs.Lookup(name); f != nil {
+		return !isBoolFlag(f.Value) && !fm.fields[fm.canonicalName(name)].opts.has("optarg")
+	}
+	return fm.catchallFor(name) != nil
+}
+
+// checkMaxArgs rejects args giving more flags than the MaxArgs option
+// allows, before any other work is done on them.
+func (fm *FlagMaker) checkMaxArgs(args []string) error {
+	count := 0
+	for s := fm.scanArgs(args); s.next(); {
+		if count++; count > fm.opts.MaxArgs {
+			return fmt.Errorf("too many flags, at most %d are allowed", fm.opts.MaxArgs)
+		}
+	}
+	return nil
+}
+
+// expandOptArgs rewrites the flags with the optarg option given without a
+// value in args, e.g. --color, as given the value of the option, e.g.
+// --color=always.
+func (fm *FlagMaker) expandOptArgs(args []string) []string {
+	var rewritten []string
+	for s := fm.scanArgs(args); s.next(); {
+		f := s.flag
+		if f.hasValue || fm.fs.Lookup(f.name) == nil {
+			continue
+		}
+		opts := fm.fields[fm.canonicalName(f.name)].opts
+		if !opts.has("optarg") {
+			continue
+		}
+		if rewritten == nil {
+			rewritten = make([]string, len(args))
+			copy(rewritten, args)
+		}
+		rewritten[f.index] = args[f.index] + "=" + opts.get("optarg", "")
+	}
+	if rewritten == nil {
+		return args
+	}
+	return rewritten
+}
+
+// splitSeparators rewrites the flags given with the ValueSeparator option in
+// args, e.g. --name:value, as --name=value.
+func (fm *FlagMaker) splitSeparators(args []string) []string {
+	if len(fm.opts.ValueSeparator) == 0 {
+		return args
+	}
+	rewritten := make([]string, len(args))
+	copy(rewritten, args)
+	for s := fm.scanArgs(args); s.next(); {
+		if f := s.flag; f.sep {
+			rewritten[f.index] = f.dashes + f.name + "=" + f.value
+		}
+	}
+	return rewritten
+}
+
+// extractCatchalls removes the undefined flags matching the prefix of a
+// catchall field from args, and collects their values.
+func (fm *FlagMaker) extractCatchalls(args []string) ([]string, map[*catchall]map[string]string, error) {
+	if len(fm.catchalls) == 0 {
+		return args, nil, nil
+	}
+	vals := make(map[*catchall]map[string]string)
+	rest := make([]string, 0, len(args))
+	s := fm.scanArgs(args)
+	for s.next() {
+		f := s.flag
+		c := fm.catchallFor(f.name)
+		if fm.fs.Lookup(f.name) != nil || c == nil {
+			rest = append(rest, args[f.index])
+			if f.next {
+				rest = append(rest, f.value)
+			}
+			continue
+		}
+		if !f.hasValue {
+			return args, nil, fmt.Errorf("flag needs an argument: %s", args[f.index])
+		}
+		if vals[c] == nil {
+			vals[c] = make(map[string]string)
+		}
+		vals[c][strings.TrimPrefix(f.name, c.prefix)] = f.value
+	}
+	// the flags end there
+	rest = append(rest, s.rest()...)
+	return rest, vals, nil
+}
+
+// catchallFor returns the catchall field receiving the undefined flag name,
+// if any.
+func (fm *FlagMaker) catchallFor(name string) *catchall {
+	for _, c := range fm.catchalls {
+		if strings.HasPrefix(name, c.prefix) && len(name) > len(c.prefix) {
+			return c
+		}
+	}
+	return nil
+}
+
+// setCatchalls adds the values collected by extractCatchalls to the catchall
+// fields. The maps are replaced rather than modified, so that the parse can be
+// rolled back.
+func (fm *FlagMaker) setCatchalls(vals map[*catchall]map[string]string) {
+	for _, c := range fm.catchalls {
+		c.saved = copyValue(c.field)
+		if len(vals[c]) == 0 {
+			continue
+		}
+		m := reflect.MakeMap(c.field.Type())
+		iter := c.field.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+		for k, v := range vals[c] {
+			m.SetMapIndex(reflect.ValueOf(k).Convert(c.field.Type().Key()), reflect.ValueOf(v).Convert(c.field.Type().Elem()))
+		}
+		c.field.Set(m)
+	}
+}
+
+// isSliceFlag tells whether the fields of the type have multi-value flags.
+func (fm *FlagMaker) isSliceFlag(t reflect.Type) bool {
+	t = fm.getUnderlyingType(t)
+	return t.Kind() == reflect.Slice && t != hardwareAddrType && t != ipType
+}
+
+// singular returns the singular form of the plural name, from the Singulars
+// option or the usual English rules, e.g. proxies gives proxy, addresses gives
+// address and hosts gives host.
+func (fm *FlagMaker) singular(name string) string {
+	if s, ok := fm.opts.Singulars[name]; ok {
+		return s
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"),
+		strings.HasSuffix(lower, "zes"), strings.HasSuffix(lower, "ches"),
+		strings.HasSuffix(lower, "shes"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(lower, "ss"), !strings.HasSuffix(lower, "s"):
+		return name
+	}
+	return name[:len(name)-1]
+}
+
+// isBasicKind tells whether the kind is the one of a basic type, e.g. string,
+// bool or int.
+func isBasicKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String,
+		reflect.Bool,
+		reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// jsonPointer returns the JSON pointer made of the names in path, as
+// defined by RFC 6901, e.g. /network/tcp/readtimeout.
+func jsonPointer(path []string) string {
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+	var b strings.Builder
+	for _, name := range path {
+		b.WriteString("/")
+		b.WriteString(escape.Replace(name))
+	}
+	return b.String()
+}
+
+// checkName tells whether a flag can be defined with the name. Name collisions,
+// e.g. when collapsing single field embedded structs or with the flags already
+// defined on a FlagSet given to RegisterInto, are recorded as an error
+// returned by defineFlags.
+func (fm *FlagMaker) checkName(name string) bool {
+	if fm.fs.Lookup(name) != nil {
+		fm.setErr(fmt.Errorf("flag %s is defined twice", name))
+		return false
+	}
+	return true
+}
+
+// setErr records the first error met while defining the flags.
+func (fm *FlagMaker) setErr(err error) {
+	if fm.err == nil {
+		fm.err = err
+	}
+}
+
+// finishFlag records the field the flag was defined for, and wraps the flag
+// value with the checks and transforms requested by the tag options of the
+// field. They are applied to each raw value given on the command line, i.e. to
+// each element of slices.
+func (fm *FlagMaker) finishFlag(name string, path []string, field reflect.Value, opts tagOptions) {
+	fm.fields[name] = flagField{value: field, path: path, opts: opts}
+	fm.byPath[strings.Join(path, ".")] = name
+	if opts.has("positional") {
+		index, err := strconv.Atoi(opts.get("positional", ""))
+		if err != nil || index < 0 {
+			fm.setErr(fmt.Errorf("invalid positional option %q for flag %s", opts.get("positional", ""), name))
+		} else {
+			fm.positionals = append(fm.positionals, positional{index: index, name: name})
+		}
+	}
+
+	var steps []func(string) (string, error)
+	if intercept := fm.opts.ValueInterceptor; intercept != nil {
+		steps = append(steps, func(str string) (string, error) {
+			return intercept(name, str)
+		})
+	}
+	if opts.has("deprecated") {
+		msg := fmt.Sprintf("flag %s is deprecated", name)
+		if reason := opts.get("deprecated", ""); len(reason) > 0 {
+			msg += ": " + reason
+		}
+		steps = append(steps, check(func(string) error {
+			fm.warn(msg)
+			return nil
+		}))
+	}
+	if opts.has("maxbytes") {
+		limit, err := strconv.Atoi(opts.get("maxbytes", ""))
+		if err != nil || limit < 0 {
+			fm.setErr(fmt.Errorf("invalid maxbytes option %q for flag %s", opts.get("maxbytes", ""), name))
+		} else {
+			steps = append(steps, check(maxBytes(limit)))
+		}
+	}
+	if cutset := opts.get("cut", ""); len(cutset) > 0 {
+		// the option is ignored by the other kinds
+		if stringish(field) {
+			steps = append(steps, func(str string) (string, error) {
+				return strings.Trim(str, cutset), nil
+			})
+		}
+	}
+	if opts.has("confirm") {
+		if !isBoolFlag(fm.fs.Lookup(name).Value) {
+			fm.setErr(fmt.Errorf("confirm option is only supported for bools, not for flag %s", name))
+		} else if token := opts.get("confirm", ""); len(token) > 0 {
+			steps = append(steps, confirmToken(name, token))
+		} else {
+			fm.confirms = true
+		}
+	}
+	if opts.has("clock") && field.Type() != durationType {
+		fm.setErr(fmt.Errorf("clock option is only supported for durations, not for flag %s", name))
+	}
+	if opts.has("optarg") && isBoolFlag(fm.fs.Lookup(name).Value) {
+		fm.setErr(fmt.Errorf("optarg option is not supported for bools, as for flag %s", name))
+	}
+	if opts.has("transform") {
+		transform, ok := fm.transforms[opts.get("transform", "")]
+		if !ok {
+			fm.setErr(fmt.Errorf("unknown transform %q for flag %s", opts.get("transform", ""), name))
+		} else {
+			steps = append(steps, transform)
+		}
+	}
+	if opts.has("coerce") {
+		if field.Kind() != reflect.Slice || field.Type().Elem().Kind() != reflect.Int {
+			fm.setErr(fmt.Errorf("coerce option is only supported for []int, not for flag %s", name))
+		} else {
+			steps = append(steps, coerceInt)
+		}
+	}
+	if opts.has("oneof") {
+		steps = append(steps, check(oneOf(strings.Fields(opts.get("oneof", "")))))
+	}
+	if opts.has("mindur") {
+		floor, err := time.ParseDuration(opts.get("mindur", ""))
+		switch {
+		case err != nil:
+			fm.setErr(fmt.Errorf("invalid mindur option %q for flag %s", opts.get("mindur", ""), name))
+		case field.Type() != durationType && !opts.has("durationms") && !opts.has("duration"):
+			fm.setErr(fmt.Errorf("mindur option is only supported for durations, not for flag %s", name))
+		default:
+			steps = append(steps, fm.minDuration(name, floor, opts.has("strictmin"), opts.has("clock")))
+		}
+	}
+	if opts.has("canonical") {
+		canonicalize, ok := fm.canonicalizers[opts.get("canonical", "")]
+		switch {
+		case !fm.requireStringish(name, field, "canonical"):
+		case !ok:
+			fm.setErr(fmt.Errorf("unknown canonicalizer %q for flag %s", opts.get("canonical", ""), name))
+		default:
+			steps = append(steps, func(str string) (string, error) {
+				return canonicalize(str), nil
+			})
+		}
+	}
+	if opts.has("clean") {
+		switch mode := opts.get("clean", ""); {
+		case !fm.requireStringish(name, field, "clean"):
+		case mode != "" && mode != "abs":
+			fm.setErr(fmt.Errorf("invalid clean option %q for flag %s", mode, name))
+		default:
+			steps = append(steps, cleanPath(mode == "abs"))
+		}
+	}
+	// the options are checked even when the lookups or stats are skipped
+	if opts.has("resolvable") && fm.requireStringish(name, field, "resolvable") && !fm.opts.SkipLookups {
+		resolver := fm.opts.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		steps = append(steps, check(resolvable(resolver, fm.opts.LookupTimeout)))
+	}
+	if opts.has("mustexist") && fm.requireStringish(name, field, "mustexist") {
+		switch mode := opts.get("mustexist", ""); {
+		case mode != "" && mode != "dir" && mode != "file":
+			fm.setErr(fmt.Errorf("invalid mustexist option %q for flag %s", mode, name))
+		case !fm.opts.SkipStats:
+			stat := fm.opts.Stat
+			if stat == nil {
+				stat = os.Stat
+			}
+			steps = append(steps, check(mustExist(stat, mode)))
+		}
+	}
+	if _, ok := fm.allowed[name]; ok {
+		steps = append(steps, check(fm.allowedValues(name)))
+	}
+	f := fm.fs.Lookup(name)
+	if usage, ok := fm.usages[name]; ok {
+		f.Usage = usage
+	}
+	if opts.has("append") {
+		if field.Kind() != reflect.Slice {
+			fm.setErr(fmt.Errorf("append option is only supported for slices, not for flag %s", name))
+		} else {
+			f.Value = newAppendValue(f.Value.(flag.Getter), field)
+		}
+	}
+	if len(steps) > 0 {
+		f.Value = newCheckedValue(f.Value.(flag.Getter), field, steps)
+	}
+	if _, ok := baseValue(f.Value).(multiValue); ok && opts.has("lines") {
+		f.Value = newSplitValue(f.Value.(flag.Getter), splitLines)
+	}
+	if opts.has("runes") && (field.Kind() != reflect.Slice || field.Type().Elem() != runeType) {
+		fm.setErr(fmt.Errorf("runes option is only supported for []rune, not for flag %s", name))
+	}
+	if opts.has("ranges") {
+		if field.Kind() != reflect.Slice || field.Type().Elem().Kind() != reflect.Int {
+			fm.setErr(fmt.Errorf("ranges option is only supported for []int, not for flag %s", name))
+		} else {
+			f.Value = newSplitValue(f.Value.(flag.Getter), splitRanges)
+		}
+	}
+	if opts.has("jsonarray") {
+		if _, ok := baseValue(f.Value).(multiValue); !ok || field.Kind() != reflect.Slice {
+			fm.setErr(fmt.Errorf("jsonarray option is only supported for slices, not for flag %s", name))
+		} else {
+			f.Value = newJSONArrayValue(f.Value.(flag.Getter), field)
+		}
+	}
+	if _, ok := baseValue(f.Value).(multiValue); ok {
+		f.Value = newClearValue(f.Value.(flag.Getter), field)
+	} else if token := fm.opts.UnsetToken; len(token) > 0 {
+		f.Value = newUnsetValue(f.Value.(flag.Getter), field, token)
+	}
+	if opts.has("collect") {
+		if _, ok := baseValue(f.Value).(multiValue); !ok {
+			fm.setErr(fmt.Errorf("collect option is only supported for multi-value flags, not for flag %s", name))
+		} else {
+			c := newCollectValue(f.Value.(flag.Getter))
+			fm.collectors = append(fm.collectors, namedCollector{name: name, value: c})
+			f.Value = c
+		}
+	}
+	if format := fm.opts.ErrorFormatter; format != nil {
+		kind := field.Kind()
+		f.Value = newFormatValue(f.Value.(flag.Getter), func(str string, err error) error {
+			fm.formatted = format(name, str, kind, err)
+			return fm.formatted
+		})
+	}
+}
+
+// stringish tells whether field holds a string, or a slice of them.
+func stringish(field reflect.Value) bool {
+	kind := field.Kind()
+	if kind == reflect.Slice {
+		kind = field.Type().Elem().Kind()
+	}
+	return kind == reflect.String
+}
+
+// requireStringish reports an error if the option of the flag name is given
+// to a field which doesn't hold strings, and tells whether it does.
+func (fm *FlagMaker) requireStringish(name string, field reflect.Value, option string) bool {
+	if stringish(field) {
+		return true
+	}
+	fm.setErr(fmt.Errorf("%s option is only supported for strings, not for flag %s", option, name))
+	return false
+}
+
+// RegisterCanonicalizer registers a canonicalizer which can be applied to
+// the values of string fields with the canonical option, e.g.
+// `flag:",canonical=name"`, once they're accepted, so that the fields hold
+// their canonical form, e.g. a lower case host name. Canonicalizers must be
+// registered before parsing.
+func (fm *FlagMaker) RegisterCanonicalizer(name string, canonicalize func(string) string) {
+	fm.canonicalizers[name] = canonicalize
+}
+
+// RegisterEnum registers the names of the values of an integer enum type,
+// given by a value of the type, e.g. the map[string]int32 generated along
+// with protobuf enums: fm.RegisterEnum(pb.Color(0), pb.Color_value). The flags
+// of the fields of the type then take the names of the values rather than
+// numbers. Enums must be registered before the flags are defined.
+func (fm *FlagMaker) RegisterEnum(enum interface{}, values map[string]int32) {
+	fm.enums[reflect.TypeOf(enum)] = values
+}
+
+// RegisterCodec registers decode to parse the fields of type t, e.g. a struct
+// holding the parts of a DSN, from the value of a single flag, rather than
+// defining flags for the fields of the struct. decode is given the value of
+// the flag and the field, which it sets. Codecs must be registered before the
+// flags are defined.
+func (fm *FlagMaker) RegisterCodec(t reflect.Type, decode func(string, reflect.Value) error) {
+	fm.codecs[t] = decode
+}
+
+// RegisterFinalizer registers finalize to be called with each instance of the
+// struct type t in the object, e.g. to compute derived fields, once ParseArgs
+// applied the flags and before the cross validators. The instances nested in
+// another one are finalized first. An error fails ParseArgs, whose flags are
+// rolled back, but not the fields set by the finalizers already called.
+// Finalizers must be registered before the flags are defined.
+func (fm *FlagMaker) RegisterFinalizer(t reflect.Type, finalize func(reflect.Value) error) {
+	fm.finalizers[t] = finalize
+}
+
+// RegisterComposite registers a flag without a field of its own, e.g.
+// --production, which applies several settings at once by calling apply with
+// the object when it's set to true. Composite flags are applied before the
+// other flags, wherever they appear in the arguments, so that the flags given
+// explicitly win. Like the fields of the flags, what they set directly in the
+// object is restored if the parse fails. They must be registered before the
+// flags are defined, and only apply to ParseArgs.
+func (fm *FlagMaker) RegisterComposite(name string, apply func(obj interface{}) error) {
+	fm.composites = append(fm.composites, composite{name: name, apply: apply})
+}
+
+// requestedComposites returns the composite flags set to true in args.
+func (fm *FlagMaker) requestedComposites(args []string) []composite {
+	if len(fm.composites) == 0 {
+		return nil
+	}
+	given := fm.scan(args)
+	var requested []composite
+	for _, c := range fm.composites {
+		v, ok := given[c.name]
+		if !ok {
+			continue
+		}
+		if b, err := strconv.ParseBool(v.last); err == nil && b {
+			requested = append(requested, c)
+		}
+	}
+	return requested
+}
+
+// scanned is the last value of a flag given in the arguments, and how many
+// times it's given.
+type scanned struct {
+	last  string
+	count int
+}
+
+// scan returns the defined flags given in args, by the name they're given
+// with, without parsing their values, for the flags which must be applied
+// before the arguments are parsed. Errors are reported by the actual parse.
+func (fm *FlagMaker) scan(args []string) map[string]*scanned {
+	given := make(map[string]*scanned)
+	for s := fm.scanArgs(args); s.next(); {
+		f := s.flag
+		if fm.fs.Lookup(f.name) == nil {
+			continue
+		}
+		v := given[f.name]
+		if v == nil {
+			v = &scanned{}
+			given[f.name] = v
+		}
+		v.last = f.value
+		if !f.hasValue {
+			// as the flag package sets bool flags
+			v.last = "true"
+		}
+		v.count++
+	}
+	return given
+}
+
+// profileFlag is the flag selecting a profile registered with RegisterProfile.
+const profileFlag = "profile"
+
+// RegisterProfile registers a preset of the object, e.g. small, medium or
+// large deployments, selected with --profile name. The values of the fields
+// of the preset backed by flags are copied to the object before any other
+// flag is applied, wherever --profile appears in the arguments, so that the
+// flags given explicitly win. preset is of the type of the object, or a
+// pointer to it. Profiles must be registered before the flags are defined,
+// and only apply to ParseArgs.
+func (fm *FlagMaker) RegisterProfile(name string, preset interface{}) {
+	fm.profiles[name] = preset
+}
+
+// requestedProfile returns the name of the profile selected in args, if any.
+func (fm *FlagMaker) requestedProfile(args []string) string {
+	if len(fm.profiles) == 0 {
+		return ""
+	}
+	if v, ok := fm.scan(args)[profileFlag]; ok {
+		return v.last
+	}
+	return ""
+}
+
+// applyProfile copies the values of the fields of the preset of the profile
+// name backed by flags to the fields of obj backed by the same flags.
+func (fm *FlagMaker) applyProfile(obj interface{}, name string) error {
+	preset, ok := fm.profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile")
+	}
+	v := reflect.ValueOf(preset)
+	if v.Kind() != reflect.Ptr {
+		// the walk needs addressable fields
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p
+	}
+	if v.Type() != reflect.TypeOf(obj) {
+		return fmt.Errorf("preset is a %v, not a %T", v.Type(), obj)
+	}
+	r := fm.inspector()
+	if err := r.defineFlags(v.Interface()); err != nil {
+		return err
+	}
+	r.fs.VisitAll(func(pf *flag.Flag) {
+		field, ok := fm.fields[pf.Name]
+		if !ok {
+			return
+		}
+		if l, ok := pf.Value.(*lazyValue); ok && l.isNil() {
+			// optional values unset in the preset are left alone
+			return
+		}
+		field.value.Set(copyValue(r.fields[pf.Name].value))
+		fm.record(pf.Name, "profile")
+		if l, ok := fm.fs.Lookup(pf.Name).Value.(*lazyValue); ok {
+			l.attach()
+		}
+	})
+	return nil
+}
+
+// LoadAliases reads deprecated aliases of flags from r, one old=new pair per
+// line, e.g. network.timeout=network.tcp.readtimeout, and defines them, now if
+// the flags are already defined or along with them otherwise. Blank lines and
+// lines starting with # are ignored. Setting an alias sets the flag it stands
+// for, with a deprecation warning.
+func (fm *FlagMaker) LoadAliases(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 || len(strings.TrimSpace(kv[1])) == 0 {
+			return fmt.Errorf("invalid alias on line %d: %q", line, text)
+		}
+		alias, name := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if _, ok := fm.aliases[alias]; ok {
+			return fmt.Errorf("alias %s on line %d is defined twice", alias, line)
+		}
+		if fm.obj != nil {
+			if err := fm.defineAlias(alias, name); err != nil {
+				return err
+			}
+		}
+		fm.aliases[alias] = name
+		fm.aliasNames = append(fm.aliasNames, alias)
+	}
+	return scanner.Err()
+}
+
+// defineAlias defines the deprecated alias of the flag name.
+func (fm *FlagMaker) defineAlias(alias, name string) error {
+	f := fm.fs.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("alias %s of undefined flag %s", alias, name)
+	}
+	if fm.fs.Lookup(alias) != nil {
+		return fmt.Errorf("alias %s is already defined as a flag", alias)
+	}
+	msg := fmt.Sprintf("flag %s is deprecated, use %s instead", alias, name)
+	fm.fs.Var(newAliasValue(f.Value.(flag.Getter), func() { fm.warn(msg) }), alias, "deprecated alias of "+name)
+	return nil
+}
+
+// repeatable tells whether the flag name accumulates its values when it's
+// given more than once.
+func (fm *FlagMaker) repeatable(name string) bool {
+	f := fm.fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	_, ok := baseValue(f.Value).(multiValue)
+	return ok || fm.fields[name].opts.has("count")
+}
+
+// checkRepeats returns an error if a flag which isn't repeatable is given
+// more than once in args, counting its aliases and alternative names.
+func (fm *FlagMaker) checkRepeats(args []string) error {
+	counts := make(map[string]int)
+	for name, v := range fm.scan(args) {
+		counts[fm.canonicalName(name)] += v.count
+	}
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := fm.fields[f.Name]; !ok || err != nil {
+			return
+		}
+		if counts[f.Name] > 1 && !fm.repeatable(f.Name) {
+			err = fmt.Errorf("flag %s is given more than once", f.Name)
+		}
+	})
+	return err
+}
+
+// canonicalName returns the name of the flag an alias or an alternative name
+// stands for, or name if it's neither.
+func (fm *FlagMaker) canonicalName(name string) string {
+	if target, ok := fm.aliases[name]; ok {
+		return target
+	}
+	if target, ok := fm.alternates[name]; ok {
+		return target
+	}
+	return name
+}
+
+// SetAllowed restricts the values of the flag name to values, e.g. the names of
+// the plugins loaded at runtime, like the oneof option does for values known
+// statically. For a multi-value flag, each element is checked, and an invalid
+// element discards the whole override of the field. Calling it again replaces
+// the allowed values.
+func (fm *FlagMaker) SetAllowed(name string, values []string) {
+	_, restricted := fm.allowed[name]
+	fm.allowed[name] = append([]string(nil), values...)
+	f := fm.fs.Lookup(name)
+	if f == nil || restricted {
+		// the check is added when the flag is defined, or already was
+		return
+	}
+	fm.addStep(f, check(fm.allowedValues(name)))
+}
+
+// addStep adds step to the checks of the flag f defined for a field, where
+// finishFlag puts them: after the other checks if it has some, and otherwise
+// right around its value and append option, so that e.g. an empty value still
+// clears a multi-value flag rather than being checked.
+func (fm *FlagMaker) addStep(f *flag.Flag, step func(string) (string, error)) {
+	// holder is the Getter field of the wrapper around v, if any
+	var holder reflect.Value
+	v := f.Value
+	for {
+		if c, ok := v.(*checkedValue); ok {
+			c.steps = append(c.steps, step)
+			return
+		}
+		if _, ok := v.(*appendValue); ok || baseValue(v) == v {
+			break
+		}
+		holder = reflect.ValueOf(v).Elem().FieldByName("Getter")
+		v = v.(interface {
+			unwrap() flag.Value
+		}).unwrap()
+	}
+	c := newCheckedValue(v.(flag.Getter), fm.fields[f.Name].value, []func(string) (string, error){step})
+	if holder.IsValid() {
+		holder.Set(reflect.ValueOf(c))
+	} else {
+		f.Value = c
+	}
+}
+
+// allowedValues returns a check rejecting the values of the flag name which
+// aren't allowed by SetAllowed at the time they're set.
+func (fm *FlagMaker) allowedValues(name string) func(string) error {
+	return func(str string) error {
+		return oneOf(fm.allowed[name])(str)
+	}
+}
+
+// SetUsages sets the usage messages of flags, by flag name, e.g. from a map
+// generated from the comments of the fields. They replace the default usage
+// messages, which are the flag names, including the ones of the flags already
+// defined.
+func (fm *FlagMaker) SetUsages(usages map[string]string) {
+	for name, usage := range usages {
+		fm.usages[name] = usage
+		if f := fm.fs.Lookup(name); f != nil {
+			f.Usage = usage
+		}
+	}
+}
+
+// Freeze records the values of the fields backed by the flags defined for
+// obj, typically once it's fully parsed, so that VerifyUnchanged can later
+// detect whether they were modified. It does nothing if the flags of the
+// FlagMaker aren't defined for obj.
+func (fm *FlagMaker) Freeze(obj interface{}) {
+	if fm.obj == nil || fm.obj != obj {
+		return
+	}
+	fm.frozen = make(map[string]string)
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		fm.frozen[f.Name] = f.Value.String()
+	})
+}
+
+// VerifyUnchanged returns an error naming the first flag, in lexicographical
+// order, whose field was modified since obj was frozen with Freeze. Only the
+// fields backed by flags are verified.
+func (fm *FlagMaker) VerifyUnchanged(obj interface{}) error {
+	if fm.obj == nil || fm.obj != obj || fm.frozen == nil {
+		return fmt.Errorf("object is not frozen")
+	}
+	var err error
+	fm.fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if old, val := fm.frozen[f.Name], f.Value.String(); val != old {
+			if fm.masked(f.Name) {
+				old, val = secretMask, secretMask
+			}
+			err = fmt.Errorf("field of flag %s changed from %s to %s", f.Name, old, val)
+		}
+	})
+	return err
+}
+
+// minDuration returns a step raising the durations below floor to floor, with
+// a warning, or rejecting them if strict. With clock, durations can also be
+// given as clock times.
+func (fm *FlagMaker) minDuration(name string, floor time.Duration, strict, clock bool) func(string) (string, error) {
+	return func(str string) (string, error) {
+		parse := time.ParseDuration
+		if clock {
+			parse = parseClockDuration
+		}
+		d, err := parse(str)
+		if err != nil || d >= floor {
+			// invalid durations are rejected by the flag value
+			return str, nil
+		}
+		if strict {
+			return str, fmt.Errorf("duration %v is below the minimum %v", d, floor)
+		}
+		fm.warn(fmt.Sprintf("flag %s: duration %v is below the minimum, using %v", name, d, floor))
+		return floor.String(), nil
+	}
+}
+
+// RegisterTransform registers a transform which can be applied to the raw
+// values of fields with the transform option, e.g. `flag:",transform=name"`,
+// before they're parsed according to the type of the fields. An error returned
+// by the transform rejects the value. Transforms must be registered before
+// parsing.
+func (fm *FlagMaker) RegisterTransform(name string, transform func(string) (string, error)) {
+	fm.transforms[name] = transform
+}
+
+// warn reports a warning through the Warn option, or prints it to the output
+// of the flag set if the option isn't set.
+func (fm *FlagMaker) warn(msg string) {
+	if fm.opts.Warn != nil {
+		fm.opts.Warn(msg)
+		return
+	}
+	fmt.Fprintln(fm.fs.Output(), "warning:", msg)
+}
+
+// flagTagName is the struct tag carrying the options of the flag created for a
+// field, e.g. `flag:",layout=2006-01-02"`. The part before the first comma
+// names the flag, overriding the TagName tag, and gives its alternative names
+// if any, e.g. `flag:"timeout|readtimeout"`.
+const flagTagName = "flag"
+
+// groupTagName is the struct tag naming the group of the flags created for a
+// field, e.g. `group:"networking"`, which sections the output of
+// DescribeJSON.
+const groupTagName = "group"
+
+// tagOptions are the comma separated options of the 'flag' struct tag. An
+// option is either a bare word or a key=value pair.
+type tagOptions map[string]string
+
+func parseFlagTag(tag string) (string, tagOptions) {
+	parts := strings.Split(tag, ",")
+	opts := make(tagOptions, len(parts)-1)
+	for _, part := range parts[1:] {
+		if len(part) == 0 {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		} else {
+			opts[kv[0]] = ""
+		}
+	}
+	return parts[0], opts
+}
+
+// has tells whether the option is present, with or without a value.
+func (o tagOptions) has(key string) bool {
+	_, ok := o[key]
+	return ok
+}
+
+// get returns the value of the option, or def if the option is absent.
+func (o tagOptions) get(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// defaulter is implemented by structs that know how to populate their own
+// default values.
+type defaulter interface {
+	Defaults()
+}
+
+// callDefaults invokes the Defaults() method of the struct if it has one. It's
+// called before the fields of the struct are walked, so that flags are created
+// with the populated defaults and a parent's Defaults() runs before those of
+// its nested structs.
+func (fm *FlagMaker) callDefaults(value reflect.Value) {
+	if fm.skipDefaults || !value.CanAddr() {
+		return
+	}
+	ptr := value.Addr()
+	if !ptr.CanInterface() {
+		// unexported embedded structs cannot be converted to interface{}
+		return
+	}
+	if d, ok := ptr.Interface().(defaulter); ok {
+		d.Defaults()
+	}
+}
+
+func (fm *FlagMaker) getName(field reflect.StructField) string {
+	// the options of the tag, e.g. omitempty, don't matter here
+	name, _, _ := strings.Cut(field.Tag.Get(fm.opts.TagName), ",")
+	if names := flagTagNames(field); len(names) > 0 {
+		name = names[0]
+	}
+	if len(name) == 0 {
+		if field.Anonymous {
+			name = fm.getUnderlyingType(field.Type).Name()
+		} else {
+			name = field.Name
+		}
+		switch fm.opts.NameStyle {
+		case KebabCase:
+			return strings.Join(camelWords(name), "-")
+		case SnakeCase:
+			return strings.Join(camelWords(name), "_")
+		}
+	}
+	if fm.opts.UseLowerCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// initialisms are the acronyms camelWords tells apart in a run of capitals,
+// e.g. XML and ID in XMLID, as listed by golint.
+var initialisms = []string{
+	"ACL", "API", "ASCII", "CPU", "CSS", "DB", "DNS", "EOF", "GUID", "HTML",
+	"HTTP", "HTTPS", "ID", "IP", "JSON", "LHS", "QPS", "RAM", "RHS", "RPC",
+	"SLA", "SMTP", "SQL", "SSH", "TCP", "TLS", "TTL", "UDP", "UI", "UID",
+	"URI", "URL", "UTF8", "UUID", "VM", "XML", "XMPP", "XSRF", "XSS",
+}
+
+// camelWords splits a camel case name into lower case words. A run of
+// capitals is a word, e.g. DB in DBName, except for its last capital if a
+// lower case letter follows, e.g. HTTPPort gives http and port. A run made of
+// several initialisms is split into them, e.g. XMLID gives xml and id.
+func camelWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && !camelBoundary(runes, i) {
+			continue
+		}
+		word := string(runes[start:i])
+		if parts, ok := splitInitialisms(word); ok {
+			words = append(words, parts...)
+		} else {
+			words = append(words, word)
+		}
+		start = i
+	}
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+	return words
+}
+
+// camelBoundary tells whether a word starts at runes[i].
+func camelBoundary(runes []rune, i int) bool {
+	cur, prev := runes[i], runes[i-1]
+	switch {
+	case cur == '_' || prev == '_':
+		return false
+	case unicode.IsUpper(cur) && !unicode.IsUpper(prev):
+		return true
+	case unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+		// the last capital of a run starts the next word
+		return true
+	}
+	return false
+}
+
+// splitInitialisms splits a run of capitals made of several initialisms.
+func splitInitialisms(word string) ([]string, bool) {
+	if len(word) == 0 {
+		return nil, true
+	}
+	for _, in := range initialisms {
+		if !strings.HasPrefix(word, in) {
+			continue
+		}
+		if rest, ok := splitInitialisms(word[len(in):]); ok {
+			return append([]string{in}, rest...), true
+		}
+	}
+	return nil, false
+}
+
+// alternateNames returns the names given after the first one in the flag tag
+// of the field, e.g. readtimeout and read-timeout for
+// `flag:"timeout|readtimeout|read-timeout"`.
+func (fm *FlagMaker) alternateNames(field reflect.StructField) []string {
+	names := flagTagNames(field)
+	if len(names) < 2 {
+		return nil
+	}
+	alternates := names[1:]
+	if fm.opts.UseLowerCase {
+		for i, alt := range alternates {
+			alternates[i] = strings.ToLower(alt)
+		}
+	}
+	return alternates
+}
+
+// flagTagNames returns the |-separated names of the flag tag of the field.
+func flagTagNames(field reflect.StructField) []string {
+	tagName, _ := parseFlagTag(field.Tag.Get(flagTagName))
+	var names []string
+	for _, name := range strings.Split(tagName, "|") {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (fm *FlagMaker) getUnderlyingType(ttype reflect.Type) reflect.Type {
+	// this only deals with *T unnamed type, other unnamed types, e.g. []int, struct{}
+	// will return empty string.
+	if ttype.Kind() == reflect.Ptr {
+		return fm.getUnderlyingType(ttype.Elem())
+	}
+	return ttype
+}
+
+// Each object has its type (which prescribes the possible operations/methods
+// could be invoked; it also has an underlying 'kind', int, float, struct etc.
+// Since user can freely define types, one 'kind' of object may correpond to
+// many types. We cannot do type assertion because types of same kind are still
+// different types. Instead, we convert to the primitive types that corresponds
+// to the kinds and create flag vars. One thing to know is that, the whole point
+// of defineFlag() method is to define flag.Vars that points to certain field
+// of the struct so that command line values can modify the struct. We cannot
+// define a flag var pointing to arbitrary 'free' varible.
+
+// I wish GoLang had macro...
+var (
+	stringPtrType  = reflect.TypeOf((*string)(nil))
+	boolPtrType    = reflect.TypeOf((*bool)(nil))
+	float32PtrType = reflect.TypeOf((*float32)(nil))
+	float64PtrType = reflect.TypeOf((*float64)(nil))
+	intPtrType     = reflect.TypeOf((*int)(nil))
+	int8PtrType    = reflect.TypeOf((*int8)(nil))
+	int16PtrType   = reflect.TypeOf((*int16)(nil))
+	int32PtrType   = reflect.TypeOf((*int32)(nil))
+	int64PtrType   = reflect.TypeOf((*int64)(nil))
+	uintPtrType    = reflect.TypeOf((*uint)(nil))
+	uint8PtrType   = reflect.TypeOf((*uint8)(nil))
+	uint16PtrType  = reflect.TypeOf((*uint16)(nil))
+	uint32PtrType  = reflect.TypeOf((*uint32)(nil))
+	uint64PtrType  = reflect.TypeOf((*uint64)(nil))
+)
+
+// Types which are handled specifically rather than by their kind.
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	rgbaType         = reflect.TypeOf(color.RGBA{})
+	hardwareAddrType = reflect.TypeOf(net.HardwareAddr{})
+	ratPtrType       = reflect.TypeOf((*big.Rat)(nil))
+	runeType         = reflect.TypeOf(rune(0))
+	boolMapType      = reflect.TypeOf(map[string]bool(nil))
+	mapSliceType     = reflect.TypeOf([]map[string]string(nil))
+	stringMapType    = reflect.TypeOf(map[string]string(nil))
+	locationPtrType  = reflect.TypeOf((*time.Location)(nil))
+	ipType           = reflect.TypeOf(net.IP(nil))
+	regexpPtrType    = reflect.TypeOf((*regexp.Regexp)(nil))
+	jsonNumberType   = reflect.TypeOf(json.Number(""))
+	durationType     = reflect.TypeOf(time.Duration(0))
+)
+
+func (fm *FlagMaker) defineFlag(name string, value reflect.Value, opts tagOptions) {
 	// v must be scalar, otherwise panic
 	ptrValue := value.Addr()
 	switch value.Kind() {
@@ -407,7 +3518,7 @@ func (fm *FlagMaker) defineFlag(name string, value reflect.Value) {
 		fm.fs.BoolVar(v, name, value.Bool(), name)
 	case reflect.Int:
 		v := ptrValue.Convert(intPtrType).Interface().(*int)
-		fm.fs.IntVar(v, name, int(value.Int()), name)
+		fm.fs.Var(newIntValue(v), name, name)
 	case reflect.Int8:
 		v := ptrValue.Convert(int8PtrType).Interface().(*int8)
 		fm.fs.Var(newInt8Value(v), name, name)
@@ -418,16 +3529,26 @@ func (fm *FlagMaker) defineFlag(name string, value reflect.Value) {
 		v := ptrValue.Convert(int32PtrType).Interface().(*int32)
 		fm.fs.Var(newInt32Value(v), name, name)
 	case reflect.Int64:
+		if opts.has("durationms") || opts.has("duration") {
+			// durations given on the command line, stored as numbers of units
+			v := ptrValue.Convert(int64PtrType).Interface().(*int64)
+			fm.fs.Var(newDurationUnitValue(v, fm.durationUnit(name, opts)), name, name)
+			return
+		}
 		switch v := ptrValue.Interface().(type) {
 		case *int64:
-			fm.fs.Int64Var(v, name, value.Int(), name)
+			fm.fs.Var(newInt64Value(v), name, name)
 		case *time.Duration:
+			if opts.has("clock") {
+				fm.fs.Var(newClockDurationValue(v), name, name)
+				return
+			}
 			fm.fs.DurationVar(v, name, value.Interface().(time.Duration), name)
 		default:
 			// (TODO) if one type defines time.Duration, we'll create a int64 flag for it.
 			// Find some acceptible way to deal with it.
 			vv := ptrValue.Convert(int64PtrType).Interface().(*int64)
-			fm.fs.Int64Var(vv, name, value.Int(), name)
+			fm.fs.Var(newInt64Value(vv), name, name)
 		}
 	case reflect.Float32:
 		v := ptrValue.Convert(float32PtrType).Interface().(*float32)
@@ -437,7 +3558,7 @@ func (fm *FlagMaker) defineFlag(name string, value reflect.Value) {
 		fm.fs.Float64Var(v, name, value.Float(), name)
 	case reflect.Uint:
 		v := ptrValue.Convert(uintPtrType).Interface().(*uint)
-		fm.fs.UintVar(v, name, uint(value.Uint()), name)
+		fm.fs.Var(newUintValue(v), name, name)
 	case reflect.Uint8:
 		v := ptrValue.Convert(uint8PtrType).Interface().(*uint8)
 		fm.fs.Var(newUint8Value(v), name, name)
@@ -449,7 +3570,7 @@ func (fm *FlagMaker) defineFlag(name string, value reflect.Value) {
 		fm.fs.Var(newUint32Value(v), name, name)
 	case reflect.Uint64:
 		v := ptrValue.Convert(uint64PtrType).Interface().(*uint64)
-		fm.fs.Uint64Var(v, name, value.Uint(), name)
+		fm.fs.Var(newUint64Value(v), name, name)
 	}
 }
 
@@ -467,3 +3588,105 @@ func (fm *FlagMaker) defineFloat64Slice(name string, value reflect.Value) {
 	ptrValue := value.Addr().Interface().(*[]float64)
 	fm.fs.Var(newFloat64Slice(ptrValue), name, name)
 }
+
+func (fm *FlagMaker) defineBoolSlice(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*[]bool)
+	fm.fs.Var(newBoolSlice(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineTimeSlice(name string, value reflect.Value, opts tagOptions) {
+	ptrValue := value.Addr().Interface().(*[]time.Time)
+	fm.fs.Var(newTimeSlice(ptrValue, opts.get("layout", time.RFC3339)), name, name)
+}
+
+func (fm *FlagMaker) defineIPSlice(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*[]net.IP)
+	fm.fs.Var(newIPSlice(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineMapSlice(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*[]map[string]string)
+	fm.fs.Var(newMapSlice(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineHardwareAddr(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*net.HardwareAddr)
+	fm.fs.Var(newHardwareAddrValue(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineRat(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(**big.Rat)
+	fm.fs.Var(newRatValue(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineJSONNumber(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*json.Number)
+	fm.fs.Var(newJSONNumberValue(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineRegexp(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(**regexp.Regexp)
+	fm.fs.Var(newRegexpValue(ptrValue, fm.opts.CompileTimeout), name, name)
+}
+
+func (fm *FlagMaker) defineLocation(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(**time.Location)
+	fm.fs.Var(newLocationValue(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineRGBA(name string, value reflect.Value) {
+	ptrValue := value.Addr().Interface().(*color.RGBA)
+	fm.fs.Var(newRGBAValue(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineRuneSlice(name string, value reflect.Value) {
+	ptrValue := value.Addr().Convert(reflect.TypeOf((*[]rune)(nil))).Interface().(*[]rune)
+	fm.fs.Var(newRuneSlice(ptrValue), name, name)
+}
+
+func (fm *FlagMaker) defineBoolMap(name string, value reflect.Value, opts tagOptions) {
+	ptrValue := value.Addr().Convert(reflect.PtrTo(boolMapType)).Interface().(*map[string]bool)
+	bm := newBoolMap(ptrValue)
+	bm.dupKeys = fm.dupKeys(name, opts)
+	fm.fs.Var(bm, name, name)
+}
+
+func (fm *FlagMaker) defineStringMap(name string, value reflect.Value, opts tagOptions) {
+	ptrValue := value.Addr().Convert(reflect.PtrTo(stringMapType)).Interface().(*map[string]string)
+	sm := newStringMap(ptrValue)
+	sm.dupKeys = fm.dupKeys(name, opts)
+	fm.fs.Var(sm, name, name)
+}
+
+// dupKeys returns how the map flag name handles a key given twice in a parse,
+// from its dupkeys option.
+func (fm *FlagMaker) dupKeys(name string, opts tagOptions) string {
+	switch mode := opts.get("dupkeys", dupKeysLast); mode {
+	case dupKeysLast, dupKeysFirst, dupKeysError:
+		return mode
+	default:
+		fm.setErr(fmt.Errorf("invalid dupkeys option %q for flag %s", mode, name))
+		return dupKeysLast
+	}
+}
+
+// storedUnits are the units the duration option stores durations in.
+var storedUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// durationUnit returns the unit the int64 flag name stores durations in, from
+// its duration option, or milliseconds for the durationms option.
+func (fm *FlagMaker) durationUnit(name string, opts tagOptions) time.Duration {
+	unit, ok := storedUnits[opts.get("duration", "ms")]
+	if !ok {
+		fm.setErr(fmt.Errorf("invalid duration option %q for flag %s", opts.get("duration", ""), name))
+		return time.Millisecond
+	}
+	return unit
+}
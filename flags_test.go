@@ -21,9 +21,23 @@
 package flags
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"image/color"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -117,7 +131,7 @@ func TestFlagMakerExampleFlattened(t *testing.T) {
 		"-path", "/var/log",
 	}
 
-	fm := NewFlagMakerAdv(&FlagMakingOptions{true, true, "not-care"})
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Flatten: true, TagName: "not-care"})
 	args, err := fm.ParseArgs(&cfg, args)
 
 	assert.True(t, err == nil)
@@ -530,7 +544,7 @@ func TestFlagMakerUnsupportedTypes(t *testing.T) {
 		args []string
 	}{
 		{&struct {
-			Env   map[string]string
+			Env   map[string]int
 			Level int
 		}{}, []string{"--level", "10", "--env", "hh,fgg,10"}},
 		{&struct {
@@ -652,6 +666,38 @@ func TestFlagMakerFloatSlice(t *testing.T) {
 	}
 }
 
+func TestFlagMakerBoolSlice(t *testing.T) {
+	type C struct {
+		Flags []bool
+	}
+	cases := []struct {
+		cfg      *C
+		args     []string
+		expected []bool
+	}{
+		{&C{}, []string{"--flags", "true", "--flags", "false", "--flags", "1"}, []bool{true, false, true}},
+		{&C{}, []string{}, nil},
+		{&C{[]bool{false, false}}, []string{}, []bool{false, false}},
+		{&C{[]bool{false, false}}, []string{"--flags", "true"}, []bool{true}},
+	}
+	for _, c := range cases {
+		args, err := ParseArgs(c.cfg, c.args)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(args))
+		assert.Equal(t, c.expected, c.cfg.Flags)
+	}
+
+	c := &C{[]bool{false, true}}
+	_, err := ParseArgs(c, []string{"--flags", "true", "--flags", "yes"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid value")
+	assert.Equal(t, []bool{false, true}, c.Flags)
+
+	v := newBoolSlice(&c.Flags)
+	assert.Equal(t, []bool{false, true}, v.Get())
+	assert.Equal(t, []string{"false", "true"}, v.values())
+}
+
 func TestFlagMakerInvalidSlice(t *testing.T) {
 	type C struct {
 		Levels  []int
@@ -671,11 +717,12 @@ func TestFlagMakerInvalidSlice(t *testing.T) {
 			[]float64{2.4, 5.6},
 		},
 		{
-			// however, valid values before invalid flag values WILL clear slice
+			// nor will the valid values before invalid flag values, as the
+			// whole parse is rolled back
 			&C{Levels: []int{2, 3}, Weights: []float64{2.4, 5.6}},
 			[]string{"--weights", "1.1", "--levels", "10", "--weights", "u8.2", "--levels", "abc"},
-			[]int{10},
-			[]float64{1.1},
+			[]int{2, 3},
+			[]float64{2.4, 5.6},
 		},
 	}
 
@@ -698,6 +745,7 @@ func TestFlagMakerVarGet(t *testing.T) {
 	is := []int{1, 40, 30}
 	ss := []string{"haha", "xx"}
 	fs := []float64{242.66, 7565.23, 234.67}
+	ts := []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
 	cases := []struct {
 		getter   flag.Getter
 		expected interface{}
@@ -712,9 +760,3186 @@ func TestFlagMakerVarGet(t *testing.T) {
 		{newStringSlice(&ss), ss},
 		{newIntSlice(&is), is},
 		{newFloat64Slice(&fs), fs},
+		{newTimeSlice(&ts, time.RFC3339), ts},
 	}
 
 	for _, c := range cases {
 		assert.Equal(t, c.expected, c.getter.Get())
 	}
 }
+
+type DefaultsSub struct {
+	Port int
+	Host string
+}
+
+func (d *DefaultsSub) Defaults() {
+	d.Port = 8080
+}
+
+type DefaultsCfg struct {
+	Name string
+	Sub  DefaultsSub
+	PSub *DefaultsSub
+}
+
+func (d *DefaultsCfg) Defaults() {
+	d.Name = "default"
+	d.Sub.Host = "localhost"
+}
+
+func TestFlagMakerDefaults(t *testing.T) {
+	cfg := &DefaultsCfg{}
+	args, err := ParseArgs(cfg, []string{"--sub.port", "9090"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, "default", cfg.Name)
+	assert.Equal(t, DefaultsSub{Port: 9090, Host: "localhost"}, cfg.Sub)
+	assert.Equal(t, &DefaultsSub{Port: 8080}, cfg.PSub)
+
+	// command line still wins over Defaults()
+	cfg = &DefaultsCfg{}
+	_, err = ParseArgs(cfg, []string{"--name", "override", "--psub.port", "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "override", cfg.Name)
+	assert.Equal(t, 1, cfg.PSub.Port)
+}
+
+func TestFlagMakerTimeSlice(t *testing.T) {
+	type C struct {
+		RunAt []time.Time
+		Days  []time.Time `flag:",layout=2006-01-02"`
+	}
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 2, 1, 12, 30, 0, 0, time.UTC)
+
+	c := &C{RunAt: []time.Time{time.Now()}}
+	args, err := ParseArgs(c, []string{
+		"--runat", "2024-01-01T00:00:00Z", "--runat", "2024-02-01T12:30:00Z",
+		"--days", "2024-01-01"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, []time.Time{t1, t2}, c.RunAt)
+	assert.Equal(t, []time.Time{t1}, c.Days)
+
+	_, err = ParseArgs(&C{}, []string{"--runat", "2024-01-01"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid value")
+}
+
+func TestFlagMakerCrossValidator(t *testing.T) {
+	type Pool struct {
+		MinConns int
+		MaxConns int
+	}
+	fm := NewFlagMaker()
+	fm.AddCrossValidator(func(obj interface{}) error {
+		p := obj.(*Pool)
+		if p.MinConns > p.MaxConns {
+			return fmt.Errorf("minconns %d exceeds maxconns %d", p.MinConns, p.MaxConns)
+		}
+		return nil
+	})
+	p := &Pool{MinConns: 1, MaxConns: 10}
+	args, err := fm.ParseArgs(p, []string{"--minconns", "20", "extra"})
+	assert.Error(t, err)
+	assert.Equal(t, "minconns 20 exceeds maxconns 10", err.Error())
+	assert.Equal(t, []string{"extra"}, args)
+
+	fm = NewFlagMaker()
+	called := 0
+	fm.AddCrossValidator(func(obj interface{}) error {
+		called++
+		return nil
+	})
+	p = &Pool{MinConns: 1, MaxConns: 10}
+	_, err = fm.ParseArgs(p, []string{"--minconns", "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, called)
+	assert.Equal(t, 5, p.MinConns)
+
+	// validators aren't called if parsing fails
+	_, err = fm.ParseArgs(p, []string{"--minconns", "x"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, called)
+}
+
+func TestFlagMakerDurationMs(t *testing.T) {
+	type C struct {
+		Timeout int64 `flag:",durationms"`
+		Raw     int64
+	}
+	c := &C{Timeout: 100}
+	_, err := ParseArgs(c, []string{"--raw", "7"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Timeout: 100, Raw: 7}, c)
+
+	args, err := ParseArgs(c, []string{"--timeout", "5s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, int64(5000), c.Timeout)
+
+	_, err = ParseArgs(c, []string{"--timeout", "5000"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid value")
+	assert.Equal(t, int64(5000), c.Timeout)
+
+	_, err = ParseArgs(c, []string{"--timeout", "1500us"})
+	assert.EqualError(t, err, `invalid value "1500us" for flag -timeout: duration 1500us is not a whole number of 1ms`)
+	assert.Equal(t, int64(5000), c.Timeout)
+
+	v := newDurationUnitValue(&c.Timeout, time.Millisecond)
+	assert.Equal(t, "5s", v.String())
+	assert.Equal(t, int64(5000), v.Get())
+
+	// the unit is given by the duration option
+	type units struct {
+		Nanos int64 `flag:",duration=ns"`
+		Secs  int64 `flag:",duration=s"`
+	}
+	u := &units{}
+	_, err = ParseArgs(u, []string{"--nanos", "1.5us", "--secs", "2m"})
+	assert.Nil(t, err)
+	assert.Equal(t, &units{Nanos: 1500, Secs: 120}, u)
+	_, err = ParseArgs(u, []string{"--secs", "1500ms"})
+	assert.Error(t, err)
+	assert.Equal(t, int64(120), u.Secs)
+
+	_, err = ParseArgs(&struct {
+		Timeout int64 `flag:",duration=days"`
+	}{}, nil)
+	assert.EqualError(t, err, `invalid duration option "days" for flag timeout`)
+
+	// durations beyond the range of time.Duration are still rendered
+	u.Secs = math.MaxInt64 / 1000
+	v = newDurationUnitValue(&u.Secs, time.Second)
+	assert.Equal(t, "9223372036854775*1s", v.String())
+}
+
+func TestFlagMakerOneOf(t *testing.T) {
+	type C struct {
+		Regions []string `flag:",oneof=us-east us-west eu"`
+		Mode    string   `flag:",oneof=dev prod"`
+		Debug   bool     `flag:",oneof=true false"`
+	}
+	c := &C{Regions: []string{"eu"}, Mode: "dev"}
+	args, err := ParseArgs(c, []string{"--regions", "us-east", "--regions", "us-west", "--mode", "prod", "--debug"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, &C{Regions: []string{"us-east", "us-west"}, Mode: "prod", Debug: true}, c)
+
+	c = &C{Regions: []string{"eu"}, Mode: "dev"}
+	_, err = ParseArgs(c, []string{"--regions", "us-east", "--regions", "mars", "--regions", "us-west"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"mars" is not one of us-east, us-west, eu`)
+	// the valid elements before the invalid one are discarded too
+	assert.Equal(t, []string{"eu"}, c.Regions)
+
+	_, err = ParseArgs(c, []string{"--mode", "test"})
+	assert.Error(t, err)
+	assert.Equal(t, "dev", c.Mode)
+
+	// so are they when the wrapped value rejects the invalid one
+	ports := []int{80}
+	v := newCheckedValue(newIntSlice(&ports), reflect.ValueOf(&ports).Elem(), []func(string) (string, error){check(oneOf([]string{"443", "8080", "x"}))})
+	assert.Nil(t, v.Set("443"))
+	assert.Equal(t, []int{443}, ports)
+	assert.Error(t, v.Set("x"))
+	assert.Equal(t, []int{80}, ports)
+}
+
+func TestFlagMakerSliceResetPerParse(t *testing.T) {
+	type C struct {
+		Hosts []string
+	}
+	c := &C{}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(c, []string{"--hosts", "h1", "--hosts", "h2"})
+	assert.Nil(t, err)
+	_, err = fm.ParseArgs(c, []string{"--hosts", "h3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"h3"}, c.Hosts)
+}
+
+func TestFlagMakerDeprecated(t *testing.T) {
+	type C struct {
+		OldHost string `flag:",deprecated=use --host; removed in 2.0"`
+		OldPort int    `flag:",deprecated"`
+		Host    string
+	}
+	var warnings []string
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		TagName:      "yaml",
+		Warn: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+	})
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--host", "h1"})
+	assert.Nil(t, err)
+	assert.Nil(t, warnings)
+
+	_, err = fm.ParseArgs(c, []string{"--oldhost", "h2", "--oldport", "80"})
+	assert.Nil(t, err)
+	assert.Equal(t, "h2", c.OldHost)
+	assert.Equal(t, 80, c.OldPort)
+	assert.Equal(t, []string{
+		"flag oldhost is deprecated: use --host; removed in 2.0",
+		"flag oldport is deprecated",
+	}, warnings)
+}
+
+func TestFlagMakerTriStateBool(t *testing.T) {
+	type C struct {
+		Feature *bool
+	}
+	cases := []struct {
+		args     []string
+		expected *bool
+	}{
+		{[]string{}, nil},
+		{[]string{"--feature"}, func() *bool { b := true; return &b }()},
+		{[]string{"--feature=false"}, func() *bool { b := false; return &b }()},
+	}
+	for _, c := range cases {
+		cfg := &C{}
+		args, err := ParseArgs(cfg, c.args)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(args))
+		assert.Equal(t, c.expected, cfg.Feature, "%v", c.args)
+	}
+
+	// non-nil pointers are overridden in place
+	b := true
+	cfg := &C{Feature: &b}
+	_, err := ParseArgs(cfg, []string{"--feature=false"})
+	assert.Nil(t, err)
+	assert.False(t, b)
+}
+
+func TestFlagMakerParseArgsFS(t *testing.T) {
+	cfg := &Cfg1{}
+	fm := NewFlagMaker()
+	fs, args, err := fm.ParseArgsFS(cfg, []string{"--logging.path", "/var/log", "extra"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"extra"}, args)
+	assert.Equal(t, "/var/log", fs.Lookup("logging.path").Value.String())
+	assert.NotNil(t, fs.Lookup("network.tcp.socket.readtimeout"))
+	assert.Equal(t, 1, fs.NArg())
+
+	fs, args, err = fm.ParseArgsFS(cfg, []string{"--unknown"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.NotNil(t, fs.Lookup("logging.path"))
+}
+
+func TestFlagMakerHardwareAddr(t *testing.T) {
+	type C struct {
+		MAC  net.HardwareAddr
+		PMAC *net.HardwareAddr
+	}
+	c := &C{}
+	args, err := ParseArgs(c, []string{"--mac", "01:23:45:67:89:ab", "--pmac", "01-23-45-67-89-AC"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, net.HardwareAddr{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}, c.MAC)
+	assert.Equal(t, "01:23:45:67:89:ac", c.PMAC.String())
+
+	_, err = ParseArgs(c, []string{"--mac", "01:23:45"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid MAC value")
+	assert.Equal(t, "01:23:45:67:89:ab", c.MAC.String())
+
+	v := newHardwareAddrValue(&c.MAC)
+	assert.Equal(t, "01:23:45:67:89:ab", v.String())
+	assert.Equal(t, c.MAC, v.Get())
+}
+
+func TestFlagMakerRat(t *testing.T) {
+	type C struct {
+		Rate  *big.Rat
+		Ratio *big.Rat
+		Unset *big.Rat
+	}
+	ratio := big.NewRat(1, 2)
+	c := &C{Ratio: ratio}
+	args, err := ParseArgs(c, []string{"--rate", "1/3", "--ratio", "0.25"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, "1/3", c.Rate.String())
+	assert.Equal(t, "1/4", c.Ratio.String())
+	// non-nil values are overridden in place
+	assert.True(t, ratio == c.Ratio)
+	assert.Nil(t, c.Unset)
+
+	_, err = ParseArgs(c, []string{"--rate", "1/x"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid fraction")
+	assert.Equal(t, "1/3", c.Rate.String())
+
+	v := newRatValue(&c.Unset)
+	assert.Equal(t, "", v.String())
+	v = newRatValue(&c.Rate)
+	assert.Equal(t, "1/3", v.String())
+	assert.Equal(t, c.Rate, v.Get())
+}
+
+type wrapper struct {
+	Value int
+}
+
+func TestFlagMakerCollapseSingles(t *testing.T) {
+	type Sub struct {
+		wrapper
+		Name string
+	}
+	type C struct {
+		wrapper
+		Sub Sub
+	}
+	cases := []struct {
+		collapse bool
+		args     []string
+	}{
+		{false, []string{"--wrapper.value", "1", "--sub.wrapper.value", "2"}},
+		{true, []string{"--value", "1", "--sub.value", "2"}},
+	}
+	for _, tc := range cases {
+		c := &C{}
+		fm := NewFlagMakerAdv(&FlagMakingOptions{
+			UseLowerCase:    true,
+			TagName:         "yaml",
+			CollapseSingles: tc.collapse,
+		})
+		args, err := fm.ParseArgs(c, tc.args)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(args))
+		assert.Equal(t, 1, c.Value)
+		assert.Equal(t, 2, c.Sub.Value)
+	}
+
+	type Collision struct {
+		wrapper
+		Value int
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase:    true,
+		TagName:         "yaml",
+		CollapseSingles: true,
+	})
+	_, err := fm.ParseArgs(&Collision{}, []string{"--value", "1"})
+	assert.Error(t, err)
+	assert.Equal(t, "flag value is defined twice", err.Error())
+}
+
+func TestFlagMakerPositional(t *testing.T) {
+	type C struct {
+		Path    string `flag:",positional=0"`
+		Count   int    `flag:",positional=2"`
+		Verbose bool
+	}
+	c := &C{}
+	args, err := ParseArgs(c, []string{"file.txt", "--verbose"})
+	assert.Nil(t, err)
+	assert.Equal(t, "file.txt", c.Path)
+	// flag parsing stops at the first non-flag argument
+	assert.False(t, c.Verbose)
+	assert.Equal(t, []string{"--verbose"}, args)
+
+	c = &C{}
+	args, err = ParseArgs(c, []string{"--verbose", "in.txt", "out.txt", "3", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Path: "in.txt", Count: 3, Verbose: true}, c)
+	assert.Equal(t, []string{"out.txt", "rest"}, args)
+
+	// out of range positions leave the field unchanged
+	c = &C{Count: 7}
+	args, err = ParseArgs(c, []string{"--path", "p", "in.txt"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Path: "in.txt", Count: 7}, c)
+	assert.Equal(t, 0, len(args))
+
+	_, err = ParseArgs(&C{}, []string{"in.txt", "out.txt", "x"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "positional argument 2")
+
+	_, err = ParseArgs(&struct {
+		Path string `flag:",positional=first"`
+	}{}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid positional option")
+}
+
+func TestFlagMakerSortDedup(t *testing.T) {
+	type C struct {
+		Sorted  []int    `flag:",sort"`
+		Unique  []int    `flag:",dedup"`
+		Both    []int    `flag:",sort,dedup"`
+		Names   []string `flag:",sort,dedup"`
+		Default []int    `flag:",sort"`
+	}
+	c := &C{Default: []int{3, 1}}
+	var args []string
+	for _, name := range []string{"sorted", "unique", "both"} {
+		for _, v := range []string{"5", "1", "5", "3", "1"} {
+			args = append(args, "--"+name, v)
+		}
+	}
+	args = append(args, "--names", "b", "--names", "a", "--names", "b")
+	args, err := ParseArgs(c, args)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, []int{1, 1, 3, 5, 5}, c.Sorted)
+	assert.Equal(t, []int{5, 1, 3}, c.Unique)
+	assert.Equal(t, []int{1, 3, 5}, c.Both)
+	assert.Equal(t, []string{"a", "b"}, c.Names)
+	// fields not overridden are left as is
+	assert.Equal(t, []int{3, 1}, c.Default)
+}
+
+func TestFlagMakerKeepPath(t *testing.T) {
+	type Primary struct {
+		Host string
+		Port int `flag:",keeppath"`
+	}
+	type Replica struct {
+		Addr string
+		Port int `flag:",keeppath"`
+	}
+	type C struct {
+		Primary Primary
+		Replica *Replica
+	}
+	c := &C{}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Flatten: true, TagName: "yaml"})
+	args, err := fm.ParseArgs(c, []string{
+		"--host", "h1", "--primary.port", "80",
+		"--addr", "h2", "--replica.port", "81"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, &C{Primary: Primary{Host: "h1", Port: 80}, Replica: &Replica{Addr: "h2", Port: 81}}, c)
+
+	// without flattening, the option changes nothing
+	c = &C{}
+	_, err = ParseArgs(c, []string{"--primary.host", "h1", "--primary.port", "80"})
+	assert.Nil(t, err)
+	assert.Equal(t, Primary{Host: "h1", Port: 80}, c.Primary)
+}
+
+func TestFlagMakerFieldFilter(t *testing.T) {
+	var paths []string
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		TagName:      "yaml",
+		FieldFilter: func(path []string, field reflect.StructField) bool {
+			paths = append(paths, strings.Join(path, "."))
+			return path[0] != "network"
+		},
+	})
+	cfg := &Cfg1{}
+	_, err := fm.ParseArgs(cfg, []string{"--logging.path", "/var/log"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/log", cfg.Path)
+	assert.Equal(t, []string{"logging", "logging.interval", "logging.path", "network"}, paths)
+
+	_, err = fm.ParseArgs(cfg, []string{"--network.readtimeout", "1s"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "flag provided but not defined")
+}
+
+func TestFlagMakerRuneSlice(t *testing.T) {
+	type C struct {
+		Delimiters []rune `flag:",runes"`
+		Codes      []int32
+	}
+	c := &C{Delimiters: []rune{'|'}}
+	args, err := ParseArgs(c, []string{"--delimiters", ",", "--delimiters", ";:", "--delimiters", "é"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, []rune{',', ';', ':', 'é'}, c.Delimiters)
+
+	c = &C{Delimiters: []rune{'|'}}
+	_, err = ParseArgs(c, []string{"--delimiters="})
+	assert.Nil(t, err)
+	assert.Equal(t, []rune{}, c.Delimiters)
+
+	// without the option, []int32 fields aren't taken for characters
+	fm := NewFlagMaker()
+	_, err = fm.ParseArgs(&C{}, []string{"--codes", "12"})
+	assert.EqualError(t, err, "flag provided but not defined: -codes")
+	assert.Equal(t, map[string]string{"codes": "fields of type []int32 are not supported"}, fm.Unsupported())
+
+	type bad struct {
+		Code int32 `flag:",runes"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.EqualError(t, err, "runes option is only supported for []rune, not for flag code")
+
+	v := newRuneSlice(&[]rune{'a', 'b'})
+	assert.Equal(t, []rune{'a', 'b'}, v.Get())
+	assert.Equal(t, `"ab"`, v.String())
+	assert.Equal(t, []string{"a", "b"}, v.values())
+}
+
+func TestFlagMakerWarnUnexported(t *testing.T) {
+	type creds struct {
+		User string
+	}
+	type C struct {
+		Host  string
+		port  int
+		creds creds
+	}
+	for _, warn := range []bool{false, true} {
+		var warnings []string
+		fm := NewFlagMakerAdv(&FlagMakingOptions{
+			UseLowerCase:   true,
+			TagName:        "yaml",
+			WarnUnexported: warn,
+			Warn: func(msg string) {
+				warnings = append(warnings, msg)
+			},
+		})
+		_, err := fm.ParseArgs(&C{}, []string{"--host", "h", "--port=80", "--creds.user", "u"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "flag provided but not defined: -port")
+		if !warn {
+			assert.Nil(t, warnings)
+			continue
+		}
+		assert.Equal(t, []string{
+			"flag port matches the unexported field flags.C.port, which cannot be overridden",
+			"flag creds.user matches the unexported field flags.C.creds, which cannot be overridden",
+		}, warnings)
+	}
+}
+
+func TestFlagMakerBoolMap(t *testing.T) {
+	type Features map[string]bool
+	type C struct {
+		Features Features
+		Flags    map[string]bool
+	}
+	c := &C{Flags: map[string]bool{"old": true}}
+	args, err := ParseArgs(c, []string{"--features", "x=true", "--features", "y", "--features", "z=0",
+		"--flags", "new"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, Features{"x": true, "y": true, "z": false}, c.Features)
+	// the first value replaces the map
+	assert.Equal(t, map[string]bool{"new": true}, c.Flags)
+
+	for _, arg := range []string{"x=maybe", "=true"} {
+		_, err = ParseArgs(&C{}, []string{"--features", arg})
+		assert.Error(t, err, arg)
+		assert.Contains(t, err.Error(), "invalid value", arg)
+	}
+
+	v := newBoolMap(&c.Flags)
+	assert.Equal(t, map[string]bool{"new": true}, v.Get())
+	assert.Equal(t, []string{"new=true"}, v.values())
+}
+
+func TestFlagMakerStringMap(t *testing.T) {
+	type Labels map[string]string
+	type C struct {
+		Labels Labels
+		Env    map[string]string `flag:",dupkeys=error"`
+	}
+	c := &C{Env: map[string]string{"old": "1"}}
+	args, err := ParseArgs(c, []string{"--labels", "k1=v1", "--labels", "k2=v2", "--labels", "q=a=b",
+		"--env", "new="})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, Labels{"k1": "v1", "k2": "v2", "q": "a=b"}, c.Labels)
+	// the first value replaces the map
+	assert.Equal(t, map[string]string{"new": ""}, c.Env)
+
+	_, err = ParseArgs(&C{}, []string{"--labels", "k1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid pair "k1", expected key=value`)
+
+	_, err = ParseArgs(&C{}, []string{"--labels", "=v"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing key")
+
+	_, err = ParseArgs(&C{}, []string{"--env", "a=1", "--env", "a=2"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate key "a"`)
+
+	v := newStringMap(&c.Env)
+	assert.Equal(t, map[string]string{"new": ""}, v.Get())
+	assert.Equal(t, []string{"new="}, v.values())
+}
+
+func TestFlagMakerMapTopLevel(t *testing.T) {
+	m := map[string]string{"keep": "me"}
+	args, err := ParseArgs(&m, []string{"--Name", "svc", "-level=3", "--network.tcp.readtimeout", "5ms",
+		"--verbose", "--empty=", "rest", "--x", "y"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest", "--x", "y"}, args)
+	assert.Equal(t, map[string]string{
+		"keep":                    "me",
+		"Name":                    "svc",
+		"level":                   "3",
+		"network.tcp.readtimeout": "5ms",
+		"verbose":                 "true",
+		"empty":                   "",
+	}, m)
+
+	var mi map[string]interface{}
+	args, err = ParseArgs(&mi, []string{"--a", "1", "--", "--b", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--b", "2"}, args)
+	assert.Equal(t, map[string]interface{}{"a": "1"}, mi)
+
+	_, err = ParseArgs(&m, []string{"---a", "1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad flag syntax")
+
+	// other maps are still rejected
+	mb := map[string]int{}
+	_, err = ParseArgs(&mb, []string{"--a", "1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "object must be a pointer to struct or interface")
+}
+
+func TestFlagMakerTransform(t *testing.T) {
+	type C struct {
+		Path  string   `flag:",transform=home"`
+		Paths []string `flag:",transform=home"`
+		Other string
+	}
+	fm := NewFlagMaker()
+	fm.RegisterTransform("home", func(s string) (string, error) {
+		if s == "~" || strings.HasPrefix(s, "~/") {
+			return "/home/test" + s[1:], nil
+		}
+		if strings.HasPrefix(s, "~") {
+			return "", fmt.Errorf("cannot expand %s", s)
+		}
+		return s, nil
+	})
+	c := &C{}
+	args, err := fm.ParseArgs(c, []string{"--path", "~/data", "--paths", "~", "--paths", "/tmp", "--other", "~/x"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, &C{Path: "/home/test/data", Paths: []string{"/home/test", "/tmp"}, Other: "~/x"}, c)
+
+	_, err = fm.ParseArgs(c, []string{"--path", "~bob/data"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot expand ~bob/data")
+	assert.Equal(t, "/home/test/data", c.Path)
+
+	_, err = NewFlagMaker().ParseArgs(&C{}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, `unknown transform "home" for flag path`, err.Error())
+}
+
+func TestFlagMakerLines(t *testing.T) {
+	type C struct {
+		Hosts []string `flag:",lines"`
+		Ports []int    `flag:",lines"`
+		Raw   []string
+	}
+	c := &C{Hosts: []string{"old"}}
+	args, err := ParseArgs(c, []string{
+		"--hosts", "h1\n  h2  \n\n\th3\r\n",
+		"--hosts", "h4",
+		"--ports", "80\n443",
+		"--raw", "a\nb"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.Equal(t, []string{"h1", "h2", "h3", "h4"}, c.Hosts)
+	assert.Equal(t, []int{80, 443}, c.Ports)
+	assert.Equal(t, []string{"a\nb"}, c.Raw)
+
+	_, err = ParseArgs(c, []string{"--ports", "80\nx"})
+	assert.Error(t, err)
+
+	parseBareBool(t, func(v flag.Getter) flag.Value { return newSplitValue(v, splitLines) })
+}
+
+// parseBareBool parses a bare --x, whose bool flag is wrapped with wrap,
+// followed by an argument the flag mustn't take as its value.
+func parseBareBool(t *testing.T, wrap func(flag.Getter) flag.Value) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	b := fs.Bool("b", false, "")
+	fs.Var(wrap(fs.Lookup("b").Value.(flag.Getter)), "x", "")
+	assert.Nil(t, fs.Parse([]string{"--x", "arg"}))
+	assert.True(t, *b)
+	assert.Equal(t, []string{"arg"}, fs.Args())
+}
+
+func TestFlagMakerRequired(t *testing.T) {
+	type TLS struct {
+		Mode string
+		Cert string `flag:",requiredif=mode=prod"`
+	}
+	type C struct {
+		Name string `flag:",required"`
+		TLS  TLS
+	}
+	cases := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--name", "svc"}, ""},
+		{[]string{"--tls.mode", "dev"}, "flag name is required"},
+		{[]string{"--name", "svc", "--tls.mode", "dev"}, ""},
+		{[]string{"--name", "svc", "--tls.mode", "prod"}, "flag tls.cert is required when tls.mode is prod"},
+		{[]string{"--name", "svc", "--tls.mode", "prod", "--tls.cert", "c.pem"}, ""},
+	}
+	for _, tc := range cases {
+		_, err := ParseArgs(&C{}, tc.args)
+		if tc.err == "" {
+			assert.Nil(t, err, "%v", tc.args)
+		} else {
+			assert.Error(t, err, "%v", tc.args)
+			assert.Equal(t, tc.err, err.Error(), "%v", tc.args)
+		}
+	}
+
+	// the condition applies to the parsed value, including the default one
+	_, err := ParseArgs(&C{Name: "svc", TLS: TLS{Mode: "prod"}}, nil)
+	assert.Error(t, err)
+
+	_, err = ParseArgs(&struct {
+		Cert string `flag:",requiredif=level=3"`
+	}{}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown field level")
+}
+
+func TestFlagMakerRegisterInto(t *testing.T) {
+	type C struct {
+		Name string
+		Port int
+	}
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	verbose := fs.Bool("verbose", false, "")
+
+	var c C
+	fm := NewFlagMaker()
+	assert.Nil(t, fm.RegisterInto(&c, fs))
+	assert.Nil(t, fs.Parse([]string{"--verbose", "--name", "svc", "--port", "80"}))
+	assert.True(t, *verbose)
+	assert.Equal(t, C{Name: "svc", Port: 80}, c)
+	assert.Error(t, fm.RegisterInto(&c, fs))
+
+	// collisions with the flags of the caller are reported
+	fs = flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.String("port", "", "")
+	err := NewFlagMaker().RegisterInto(&C{}, fs)
+	assert.Error(t, err)
+	assert.Equal(t, "flag port is defined twice", err.Error())
+	// and leave the FlagSet of the caller as is
+	assert.Nil(t, fs.Lookup("name"))
+}
+
+func TestFlagMakerJSONPointer(t *testing.T) {
+	type TCP struct {
+		ReadTimeout time.Duration
+	}
+	type C struct {
+		Network struct {
+			TCP TCP
+		}
+		Path string `yaml:"a/b"`
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		Flatten:      true,
+		TagName:      "yaml",
+		JSONPointer:  true,
+	})
+	var c C
+	args, err := fm.ParseArgs(&c, []string{"--/network/tcp/readtimeout", "5ms", "--/a~1b", "x"})
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, 5*time.Millisecond, c.Network.TCP.ReadTimeout)
+	assert.Equal(t, "x", c.Path)
+
+	// the dotted form isn't defined
+	_, err = fm.ParseArgs(&c, []string{"--network.tcp.readtimeout", "5ms"})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerDefaultFunc(t *testing.T) {
+	type C struct {
+		BindAddr      string
+		AdvertiseAddr string
+	}
+	newMaker := func() *FlagMaker {
+		return NewFlagMakerAdv(&FlagMakingOptions{
+			UseLowerCase: true,
+			DefaultFunc: map[string]func(obj interface{}) string{
+				"advertiseaddr": func(obj interface{}) string {
+					return obj.(*C).BindAddr
+				},
+			},
+		})
+	}
+
+	c := C{BindAddr: "0.0.0.0:80"}
+	_, err := newMaker().ParseArgs(&c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:80", c.AdvertiseAddr)
+
+	c = C{BindAddr: "0.0.0.0:80"}
+	_, err = newMaker().ParseArgs(&c, []string{"--advertiseaddr", "10.0.0.1:80"})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:80", c.AdvertiseAddr)
+
+	// non-zero fields are left alone
+	c = C{BindAddr: "0.0.0.0:80", AdvertiseAddr: "10.0.0.2:80"}
+	_, err = newMaker().ParseArgs(&c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2:80", c.AdvertiseAddr)
+
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{
+		DefaultFunc: map[string]func(obj interface{}) string{
+			"missing": func(interface{}) string { return "" },
+		},
+	}).ParseArgs(&C{}, nil)
+	assert.Error(t, err)
+
+	// the computed defaults aren't values given by the user
+	type D struct {
+		Host    string
+		OldHost string `flag:",deprecated"`
+		Wipe    bool   `flag:",confirm"`
+	}
+	var warnings []string
+	d := D{Host: "example.com"}
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		Warn: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+		DefaultFunc: map[string]func(obj interface{}) string{
+			"oldhost": func(obj interface{}) string { return obj.(*D).Host },
+			"wipe":    func(interface{}) string { return "true" },
+		},
+	}).ParseArgs(&d, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, warnings)
+	assert.Equal(t, D{Host: "example.com", OldHost: "example.com", Wipe: true}, d)
+}
+
+func TestFlagMakerAppend(t *testing.T) {
+	type C struct {
+		Paths []string `flag:",append"`
+		Ports []int    `flag:",append,oneof=80 443 8080"`
+	}
+	c := C{Paths: []string{"/usr/lib", "/lib"}, Ports: []int{80}}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(&c, []string{"--paths", "/opt/lib", "--ports", "443", "--paths", "/home/lib"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/usr/lib", "/lib", "/opt/lib", "/home/lib"}, c.Paths)
+	assert.Equal(t, []int{80, 443}, c.Ports)
+
+	// a later parse appends to the result of the previous one
+	_, err = fm.ParseArgs(&c, []string{"--paths", "/srv/lib"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/usr/lib", "/lib", "/opt/lib", "/home/lib", "/srv/lib"}, c.Paths)
+
+	// a rejected value discards the whole override
+	_, err = fm.ParseArgs(&c, []string{"--ports", "8080", "--ports", "22"})
+	assert.Error(t, err)
+	assert.Equal(t, []int{80, 443}, c.Ports)
+
+	_, err = ParseArgs(&struct {
+		Name string `flag:",append"`
+	}{}, nil)
+	assert.Error(t, err)
+
+	var flags []bool
+	parseBareBool(t, func(v flag.Getter) flag.Value {
+		return newAppendValue(v, reflect.ValueOf(&flags).Elem())
+	})
+}
+
+func TestFlagMakerLocation(t *testing.T) {
+	type C struct {
+		TZ    *time.Location
+		Other *time.Location
+	}
+	var c C
+	args, err := ParseArgs(&c, []string{"--tz", "UTC"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+	assert.True(t, time.UTC == c.TZ)
+	assert.Nil(t, c.Other)
+
+	_, err = ParseArgs(&c, []string{"--tz", "Mars/Olympus_Mons"})
+	assert.Error(t, err)
+	assert.True(t, time.UTC == c.TZ)
+
+	v := newLocationValue(&c.Other)
+	assert.Equal(t, "", v.String())
+	v = newLocationValue(&c.TZ)
+	assert.Equal(t, "UTC", v.String())
+	assert.Equal(t, time.UTC, v.Get())
+}
+
+func TestFlagMakerMaxBytes(t *testing.T) {
+	type C struct {
+		Name string   `flag:",maxbytes=4"`
+		Tags []string `flag:",maxbytes=2"`
+	}
+	c := C{Name: "none", Tags: []string{"a"}}
+	_, err := ParseArgs(&c, []string{"--name", "abcd", "--tags", "ab"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Name: "abcd", Tags: []string{"ab"}}, c)
+
+	_, err = ParseArgs(&c, []string{"--name", "abcde"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "value too long (max 4 bytes)")
+	assert.Equal(t, "abcd", c.Name)
+
+	_, err = ParseArgs(&c, []string{"--tags", "x", "--tags", "xyz"})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"ab"}, c.Tags)
+
+	_, err = ParseArgs(&struct {
+		Name string `flag:",maxbytes=x"`
+	}{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerResolveRefs(t *testing.T) {
+	type C struct {
+		Data struct {
+			Dir string
+		}
+		Log struct {
+			Path string
+			Name string
+		}
+		Port int
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, ResolveRefs: true})
+	var c C
+	c.Log.Name = "app-${port}"
+	_, err := fm.ParseArgs(&c, []string{
+		"--log.path", "${data.dir}/log/${log.name}",
+		"--data.dir", "/var/lib/app",
+		"--port", "80",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/lib/app/log/app-80", c.Log.Path)
+	assert.Equal(t, "app-80", c.Log.Name)
+
+	var c2 C
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, ResolveRefs: true})
+	_, err = fm.ParseArgs(&c2, []string{"--log.path", "${data.home}/log"})
+	assert.Error(t, err)
+	assert.Equal(t, "flag log.path references undefined flag data.home", err.Error())
+
+	var c3 C
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, ResolveRefs: true})
+	_, err = fm.ParseArgs(&c3, []string{"--log.path", "${log.name}", "--log.name", "${log.path}"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cyclic reference")
+
+	// references are left alone without the option
+	var c4 C
+	_, err = ParseArgs(&c4, []string{"--log.path", "${data.dir}/log"})
+	assert.Nil(t, err)
+	assert.Equal(t, "${data.dir}/log", c4.Log.Path)
+}
+
+func TestFlagMakerFreeze(t *testing.T) {
+	type C struct {
+		Name  string
+		Hosts []string
+		skip  int
+	}
+	c := C{Hosts: []string{"a"}}
+	fm := NewFlagMaker()
+	assert.Error(t, fm.VerifyUnchanged(&c))
+	_, err := fm.ParseArgs(&c, []string{"--name", "svc"})
+	assert.Nil(t, err)
+	fm.Freeze(&c)
+	assert.Nil(t, fm.VerifyUnchanged(&c))
+
+	// fields not backed by flags aren't verified
+	c.skip = 1
+	assert.Nil(t, fm.VerifyUnchanged(&c))
+
+	c.Hosts[0] = "b"
+	err = fm.VerifyUnchanged(&c)
+	assert.Error(t, err)
+	assert.Equal(t, "field of flag hosts changed from [a] to [b]", err.Error())
+
+	// freezing another object does nothing
+	fm.Freeze(&C{})
+	assert.Error(t, fm.VerifyUnchanged(&c))
+	assert.Error(t, fm.VerifyUnchanged(&C{}))
+}
+
+func TestFlagMakerIPSlice(t *testing.T) {
+	type C struct {
+		DNS   []net.IP
+		Allow []net.IP `flag:",dedup"`
+	}
+	c := C{DNS: []net.IP{net.ParseIP("9.9.9.9")}}
+	_, err := ParseArgs(&c, []string{"--dns", "8.8.8.8", "--dns", "1.1.1.1", "--allow", "::1", "--allow", "::1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("1.1.1.1")}, c.DNS)
+	assert.Equal(t, []net.IP{net.ParseIP("::1")}, c.Allow)
+
+	v := newIPSlice(&c.DNS)
+	assert.Equal(t, c.DNS, v.Get())
+	assert.Equal(t, []string{"8.8.8.8", "1.1.1.1"}, v.values())
+
+	_, err = ParseArgs(&c, []string{"--dns", "8.8.4.4", "--dns", "1.1.1", "--dns", "1.0.0.1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid IP address "1.1.1"`)
+}
+
+type OptsInner struct {
+	Port int `json:"port_num" flag:",transform=trim"`
+}
+
+type OptsMiddle struct {
+	*OptsInner
+	Name string `json:"svc_name"`
+}
+
+type OptsOuter struct {
+	*OptsMiddle `json:"mid"`
+}
+
+func TestFlagMakerOptionsPropagation(t *testing.T) {
+	cases := []struct {
+		opts  *FlagMakingOptions
+		names []string
+	}{
+		{&FlagMakingOptions{TagName: "json"}, []string{"mid.OptsInner.port_num", "mid.svc_name"}},
+		{&FlagMakingOptions{TagName: "json", UseLowerCase: true}, []string{"mid.optsinner.port_num", "mid.svc_name"}},
+		{&FlagMakingOptions{TagName: "json", Flatten: true}, []string{"port_num", "svc_name"}},
+		{&FlagMakingOptions{TagName: "json", JSONPointer: true}, []string{"/mid/OptsInner/port_num", "/mid/svc_name"}},
+	}
+	for _, tc := range cases {
+		fm := NewFlagMakerAdv(tc.opts)
+		fm.RegisterTransform("trim", func(s string) (string, error) {
+			return strings.TrimSpace(s), nil
+		})
+		fm.SetUsages(map[string]string{tc.names[1]: "name of the service"})
+
+		// the makers used to inspect objects have the same options
+		infos, err := fm.Describe(&OptsOuter{})
+		assert.Nil(t, err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name)
+		}
+		assert.Equal(t, tc.names, names)
+		assert.Equal(t, "name of the service", infos[1].Usage)
+		assert.Equal(t, 2, len(fm.ToEnviron(&OptsOuter{}, "app")))
+
+		var c OptsOuter
+		_, err = fm.ParseArgs(&c, []string{"--" + tc.names[0], " 80 ", "--" + tc.names[1], "svc"})
+		assert.Nil(t, err, "%v", tc.names)
+		assert.Equal(t, 80, c.Port)
+		assert.Equal(t, "svc", c.Name)
+	}
+
+	// the fields of unexported structs embedded through a pointer cannot be
+	// set, so they're skipped
+	type inner struct {
+		Port int
+	}
+	c := struct {
+		*inner
+		Name string
+	}{}
+	infos, err := NewFlagMaker().Describe(&c)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, "name", infos[0].Name)
+}
+
+func TestFlagMakerConfirm(t *testing.T) {
+	type C struct {
+		AllowDataLoss bool `flag:",confirm"`
+		Wipe          bool `flag:",confirm=I-UNDERSTAND"`
+	}
+	cases := []struct {
+		args []string
+		want C
+		err  string
+	}{
+		{[]string{"--allowdataloss"}, C{}, "flag allowdataloss requires --yes to be enabled"},
+		{[]string{"--allowdataloss", "--yes"}, C{AllowDataLoss: true}, ""},
+		{[]string{"--allowdataloss=false"}, C{}, ""},
+		{[]string{"--wipe"}, C{}, "flag wipe must be set to I-UNDERSTAND to be enabled"},
+		{[]string{"--wipe=true", "--yes"}, C{}, "flag wipe must be set to I-UNDERSTAND to be enabled"},
+		{[]string{"--wipe=I-UNDERSTAND"}, C{Wipe: true}, ""},
+	}
+	for _, tc := range cases {
+		var c C
+		_, err := ParseArgs(&c, tc.args)
+		if tc.err == "" {
+			assert.Nil(t, err, "%v", tc.args)
+			assert.Equal(t, tc.want, c, "%v", tc.args)
+		} else {
+			assert.Error(t, err, "%v", tc.args)
+			assert.Contains(t, err.Error(), tc.err, "%v", tc.args)
+		}
+	}
+
+	// the struct can define the confirmation flag itself
+	var c struct {
+		Reset bool `flag:",confirm"`
+		Yes   bool
+	}
+	_, err := ParseArgs(&c, []string{"--reset", "--yes"})
+	assert.Nil(t, err)
+	assert.True(t, c.Reset)
+	assert.True(t, c.Yes)
+
+	// and so can the FlagSet given to RegisterInto
+	var r struct {
+		Reset bool `flag:",confirm"`
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "yes")
+	assert.Nil(t, NewFlagMaker().RegisterInto(&r, fs))
+	assert.Nil(t, fs.Parse([]string{"--reset", "--yes"}))
+	assert.True(t, r.Reset)
+	assert.True(t, *yes)
+
+	_, err = ParseArgs(&struct {
+		Name string `flag:",confirm"`
+	}{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerRegexp(t *testing.T) {
+	type C struct {
+		Match *regexp.Regexp
+	}
+	var c C
+	_, err := ParseArgs(&c, []string{"--match", "^a+b$"})
+	assert.Nil(t, err)
+	assert.True(t, c.Match.MatchString("aab"))
+
+	_, err = ParseArgs(&c, []string{"--match", "a("})
+	assert.Error(t, err)
+	assert.Equal(t, "^a+b$", c.Match.String())
+
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase:   true,
+		CompileTimeout: time.Nanosecond,
+	})
+	_, err = fm.ParseArgs(&c, []string{"--match", strings.Repeat(`[\p{L}\p{N}]{1000}`, 10)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out after 1ns")
+	assert.Equal(t, "^a+b$", c.Match.String())
+}
+
+func TestFlagMakerDefaultOrder(t *testing.T) {
+	type C struct {
+		Host     string
+		BindAddr string
+		Peer     string
+	}
+	newMaker := func(order ...string) *FlagMaker {
+		return NewFlagMakerAdv(&FlagMakingOptions{
+			UseLowerCase: true,
+			DefaultFunc: map[string]func(obj interface{}) string{
+				// bindaddr depends on peer, but sorts first, so the order
+				// must be reversed
+				"bindaddr": func(obj interface{}) string {
+					return obj.(*C).Peer + ":80"
+				},
+				"peer": func(obj interface{}) string {
+					return obj.(*C).Host
+				},
+			},
+			DefaultOrder: order,
+		})
+	}
+
+	var c C
+	_, err := newMaker("peer", "bindaddr").ParseArgs(&c, []string{"--host", "example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Host: "example.com", Peer: "example.com", BindAddr: "example.com:80"}, c)
+
+	c = C{}
+	_, err = newMaker().ParseArgs(&c, []string{"--host", "example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, ":80", c.BindAddr)
+
+	// the command line still wins
+	c = C{}
+	_, err = newMaker("peer").ParseArgs(&c, []string{"--host", "example.com", "--peer", "other.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Host: "example.com", Peer: "other.com", BindAddr: "other.com:80"}, c)
+
+	// even when given the value computed before the parse
+	c = C{Host: "example.com"}
+	_, err = newMaker("peer").ParseArgs(&c, []string{"--bindaddr", "example.com:80", "--host", "other.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Host: "other.com", Peer: "other.com", BindAddr: "example.com:80"}, c)
+
+	_, err = newMaker("peer", "bindaddr", "peer").ParseArgs(&C{}, nil)
+	assert.EqualError(t, err, "flag peer is listed twice in DefaultOrder")
+	_, err = newMaker("host").ParseArgs(&C{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerJSONNumber(t *testing.T) {
+	type C struct {
+		ID    json.Number
+		Ratio json.Number
+	}
+	var c C
+	_, err := ParseArgs(&c, []string{"--id", "9007199254740993", "--ratio", "-1.5e3"})
+	assert.Nil(t, err)
+	assert.Equal(t, json.Number("9007199254740993"), c.ID)
+	assert.Equal(t, json.Number("-1.5e3"), c.Ratio)
+	// the float64 nearest to the number is different
+	f, _ := c.ID.Float64()
+	assert.NotEqual(t, "9007199254740993", fmt.Sprintf("%.0f", f))
+	i, err := c.ID.Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9007199254740993), i)
+
+	for _, v := range []string{"", "0x10", "1.", "01", "NaN", "1_000"} {
+		_, err = ParseArgs(&c, []string{"--id", v})
+		assert.Error(t, err, v)
+	}
+	assert.Equal(t, json.Number("9007199254740993"), c.ID)
+
+	v := newJSONNumberValue(&c.Ratio)
+	assert.Equal(t, json.Number("-1.5e3"), v.Get())
+	assert.Equal(t, "-1.5e3", v.String())
+}
+
+func TestFlagMakerValueInterceptor(t *testing.T) {
+	type C struct {
+		Name  string
+		Hosts []string
+		Port  int `flag:",oneof=80 443"`
+	}
+	var seen []string
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		ValueInterceptor: func(name, rawValue string) (string, error) {
+			seen = append(seen, name+"="+rawValue)
+			if strings.Contains(rawValue, "evil") {
+				return "", fmt.Errorf("banned value for flag %s", name)
+			}
+			return strings.TrimSpace(rawValue), nil
+		},
+	})
+	c := C{Hosts: []string{"a"}}
+	_, err := fm.ParseArgs(&c, []string{"--name", " svc ", "--hosts", "b", "--port", " 443"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Name: "svc", Hosts: []string{"b"}, Port: 443}, c)
+	assert.Equal(t, []string{"name= svc ", "hosts=b", "port= 443"}, seen)
+
+	_, err = fm.ParseArgs(&c, []string{"--hosts", "c", "--hosts", "evil.com"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "banned value for flag hosts")
+	assert.Equal(t, []string{"b"}, c.Hosts)
+}
+
+func TestFlagMakerSingularizeSlices(t *testing.T) {
+	type C struct {
+		Hosts     []string
+		Proxies   []string
+		Addresses *[]string
+		Children  []string
+		Status    string
+		Class     []int
+		MAC       net.HardwareAddr
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase:      true,
+		SingularizeSlices: true,
+		Singulars:         map[string]string{"children": "child"},
+	})
+	var c C
+	args, err := fm.ParseArgs(&c, []string{
+		"--host", "a", "--host", "b",
+		"--proxy", "p",
+		"--address", "x",
+		"--child", "c",
+		"--status", "ok",
+		"--class", "1",
+		"--mac", "00:00:5e:00:53:01",
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, []string{"a", "b"}, c.Hosts)
+	assert.Equal(t, []string{"p"}, c.Proxies)
+	assert.Equal(t, []string{"x"}, *c.Addresses)
+	assert.Equal(t, []string{"c"}, c.Children)
+	assert.Equal(t, "ok", c.Status)
+	assert.Equal(t, []int{1}, c.Class)
+
+	_, err = fm.ParseArgs(&c, []string{"--hosts", "a"})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerCanonical(t *testing.T) {
+	type C struct {
+		Host  string   `flag:",canonical=host,oneof=example.com EXAMPLE.COM"`
+		Paths []string `flag:",canonical=path"`
+	}
+	fm := NewFlagMaker()
+	fm.RegisterCanonicalizer("host", strings.ToLower)
+	fm.RegisterCanonicalizer("path", func(s string) string {
+		return strings.TrimRight(s, "/")
+	})
+	var c C
+	_, err := fm.ParseArgs(&c, []string{"--host", "EXAMPLE.COM", "--paths", "/a/", "--paths", "/b"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Host: "example.com", Paths: []string{"/a", "/b"}}, c)
+
+	_, err = NewFlagMaker().ParseArgs(&C{}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown canonicalizer")
+
+	fm = NewFlagMaker()
+	fm.RegisterCanonicalizer("host", strings.ToLower)
+	_, err = fm.ParseArgs(&struct {
+		Port int `flag:",canonical=host"`
+	}{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerRollback(t *testing.T) {
+	type C struct {
+		Name   string
+		Hosts  []string
+		Rate   *big.Rat
+		Port   *int
+		Labels map[string]bool
+	}
+	c := C{Name: "svc", Hosts: []string{"a"}, Rate: big.NewRat(1, 2)}
+	args := []string{
+		"--name", "other",
+		"--hosts", "b", "--hosts", "c",
+		"--rate", "1/3",
+		"--port", "80",
+		"--labels", "x",
+		"--unknown",
+	}
+	_, err := ParseArgs(&c, args)
+	assert.Error(t, err)
+	assert.Equal(t, "svc", c.Name)
+	assert.Equal(t, []string{"a"}, c.Hosts)
+	assert.Equal(t, "1/2", c.Rate.String())
+	assert.Nil(t, c.Port)
+	assert.Nil(t, c.Labels)
+
+	// failing validators roll back the parse as well
+	fm := NewFlagMaker()
+	fm.AddCrossValidator(func(obj interface{}) error {
+		return fmt.Errorf("rejected")
+	})
+	_, err = fm.ParseArgs(&c, args[:len(args)-1])
+	assert.Error(t, err)
+	assert.Equal(t, "svc", c.Name)
+	assert.Nil(t, c.Port)
+}
+
+type worker struct {
+	Name   string
+	Weight int
+}
+
+func (w *worker) Defaults() {
+	w.Weight = 1
+}
+
+func TestFlagMakerStructSlice(t *testing.T) {
+	type C struct {
+		Workers []worker `flag:",grow,maxlen=8"`
+		Fixed   []worker
+		Stamps  []time.Time
+	}
+	c := C{
+		Workers: []worker{{Name: "a", Weight: 3}},
+		Fixed:   []worker{{Name: "f"}},
+	}
+	_, err := ParseArgs(&c, []string{"--workers.3.name", "x", "--workers.0.weight", "2", "--fixed.0.name", "g"})
+	assert.Nil(t, err)
+	assert.Equal(t, []worker{{Name: "a", Weight: 2}, {}, {}, {Name: "x"}}, c.Workers)
+	assert.Equal(t, []worker{{Name: "g", Weight: 1}}, c.Fixed)
+
+	// a failed parse restores the length of the slice as well
+	fm := NewFlagMaker()
+	d := C{Workers: []worker{{Name: "a"}}}
+	_, err = fm.ParseArgs(&d, []string{"--workers.2.name", "y", "--workers.1.weight", "x"})
+	assert.Error(t, err)
+	assert.Equal(t, []worker{{Name: "a", Weight: 1}}, d.Workers)
+	_, err = fm.ParseArgs(&d, []string{"--workers.1.weight", "4"})
+	assert.Nil(t, err)
+	assert.Equal(t, []worker{{Name: "a", Weight: 1}, {Weight: 4}}, d.Workers)
+
+	// the array of the slice isn't written past its length
+	shared := make([]worker, 1, 8)
+	shared = append(shared[:2], worker{Name: "kept"})[:1]
+	_, err = ParseArgs(&C{Workers: shared}, []string{"--workers.2.name", "z"})
+	assert.Nil(t, err)
+	assert.Equal(t, worker{Name: "kept"}, shared[:3][2])
+
+	// out of range indices are not defined
+	for _, args := range [][]string{
+		{"--fixed.1.name", "h"},
+		{"--workers.8.name", "y"},
+	} {
+		_, err = ParseArgs(&c, args)
+		assert.Error(t, err, "%v", args)
+	}
+
+	_, err = ParseArgs(&struct {
+		Workers []worker `flag:",grow"`
+	}{}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "maxlen")
+}
+
+func TestFlagMakerLoadAliases(t *testing.T) {
+	type C struct {
+		Network struct {
+			ReadTimeout time.Duration
+		}
+		Hosts []string `flag:",sort,required"`
+	}
+	var warnings []string
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		Warn: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+	})
+	assert.Nil(t, fm.LoadAliases(strings.NewReader(`
+# renamed in 2.0
+timeout = network.readtimeout
+host=hosts
+`)))
+	var c C
+	_, err := fm.ParseArgs(&c, []string{"--timeout", "5s", "--host", "b", "--host", "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, c.Network.ReadTimeout)
+	assert.Equal(t, []string{"a", "b"}, c.Hosts)
+	assert.Equal(t, []string{
+		"flag timeout is deprecated, use network.readtimeout instead",
+		"flag host is deprecated, use hosts instead",
+		"flag host is deprecated, use hosts instead",
+	}, warnings)
+
+	// aliases can be loaded once the flags are defined
+	assert.Nil(t, fm.LoadAliases(strings.NewReader("rt=network.readtimeout")))
+	_, err = fm.ParseArgs(&c, []string{"--rt", "1s"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, c.Network.ReadTimeout)
+
+	for _, aliases := range []string{"timeout", "=hosts", "a=b=c", "rt=hosts", "x=missing", "hosts=network.readtimeout"} {
+		assert.Error(t, fm.LoadAliases(strings.NewReader(aliases)), aliases)
+	}
+}
+
+func TestFlagMakerMinDuration(t *testing.T) {
+	type C struct {
+		Poll    time.Duration `flag:",mindur=1s"`
+		Retry   time.Duration `flag:",mindur=100ms,strictmin"`
+		Timeout int64         `flag:",durationms,mindur=1s"`
+	}
+	var warnings []string
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		Warn: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+	})
+	var c C
+	_, err := fm.ParseArgs(&c, []string{"--poll", "10ms", "--retry", "200ms", "--timeout", "1ms"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Poll: time.Second, Retry: 200 * time.Millisecond, Timeout: 1000}, c)
+	assert.Equal(t, []string{
+		"flag poll: duration 10ms is below the minimum, using 1s",
+		"flag timeout: duration 1ms is below the minimum, using 1s",
+	}, warnings)
+
+	_, err = fm.ParseArgs(&c, []string{"--poll", "2s", "--retry", "1ms"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "duration 1ms is below the minimum 100ms")
+	assert.Equal(t, time.Second, c.Poll)
+
+	for _, obj := range []interface{}{
+		&struct {
+			Poll time.Duration `flag:",mindur=x"`
+		}{},
+		&struct {
+			Count int `flag:",mindur=1s"`
+		}{},
+	} {
+		_, err = ParseArgs(obj, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestFlagMakerComposite(t *testing.T) {
+	type C struct {
+		LogLevel string
+		Replicas int
+		Debug    bool
+	}
+	production := func(obj interface{}) error {
+		c := obj.(*C)
+		c.LogLevel = "warn"
+		c.Replicas = 3
+		return nil
+	}
+	newMaker := func() *FlagMaker {
+		fm := NewFlagMaker()
+		fm.RegisterComposite("production", production)
+		return fm
+	}
+
+	var c C
+	_, err := newMaker().ParseArgs(&c, []string{"--debug", "--production"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{LogLevel: "warn", Replicas: 3, Debug: true}, c)
+
+	// explicit flags win, wherever they appear
+	c = C{}
+	_, err = newMaker().ParseArgs(&c, []string{"--replicas", "5", "--production"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{LogLevel: "warn", Replicas: 5}, c)
+
+	c = C{}
+	_, err = newMaker().ParseArgs(&c, []string{"--production=false", "--loglevel", "debug"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{LogLevel: "debug"}, c)
+
+	infos, err := newMaker().Describe(&C{})
+	assert.Nil(t, err)
+	assert.Equal(t, "production", infos[2].Name)
+	assert.Equal(t, "bool", infos[2].Type)
+
+	fm := NewFlagMaker()
+	fm.RegisterComposite("debug", production)
+	_, err = fm.ParseArgs(&C{}, nil)
+	assert.Error(t, err)
+
+	// a failed parse undoes the composite flags too
+	type tls struct {
+		Cert string
+	}
+	type D struct {
+		Replicas int
+		TLS      *tls
+		note     string
+	}
+	fm = NewFlagMaker()
+	fm.RegisterComposite("production", func(obj interface{}) error {
+		d := obj.(*D)
+		d.TLS = &tls{Cert: "prod.pem"}
+		d.note = "production"
+		return nil
+	})
+	d := &D{TLS: &tls{Cert: "dev.pem"}}
+	orig := d.TLS
+	_, err = fm.ParseArgs(d, []string{"--production", "--replicas", "x"})
+	assert.Error(t, err)
+	assert.Equal(t, &D{TLS: &tls{Cert: "dev.pem"}}, d)
+	assert.True(t, orig == d.TLS)
+
+	// and the flags still set the original fields
+	_, err = fm.ParseArgs(d, []string{"--tls.cert", "test.pem"})
+	assert.Nil(t, err)
+	assert.Equal(t, "test.pem", orig.Cert)
+}
+
+type hintTimeout int64
+
+type hintPort uint16
+
+func TestFlagMakerTypeHint(t *testing.T) {
+	type C struct {
+		Timeout hintTimeout `flag:",as=duration"`
+		Port    hintPort    `flag:",as=uint16"`
+		Plain   hintTimeout
+	}
+	c := C{Timeout: hintTimeout(time.Second)}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(&c, []string{"--timeout", "5s", "--port", "8080", "--plain", "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, C{Timeout: hintTimeout(5 * time.Second), Port: 8080, Plain: 5}, c)
+	assert.Equal(t, "1s", fm.fs.Lookup("timeout").DefValue)
+
+	_, err = ParseArgs(&c, []string{"--port", "70000"})
+	assert.Error(t, err)
+
+	for _, obj := range []interface{}{
+		&struct {
+			Port hintPort `flag:",as=string"`
+		}{},
+		&struct {
+			Port hintPort `flag:",as=short"`
+		}{},
+		&struct {
+			Ports []hintPort `flag:",as=uint16"`
+		}{},
+	} {
+		_, err = ParseArgs(obj, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestFlagMakerCollect(t *testing.T) {
+	type C struct {
+		Ports []int    `flag:",collect"`
+		Zones []string `flag:",collect,oneof=a b"`
+	}
+	c := C{Ports: []int{80}}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(&c, []string{"--ports", "x", "--ports", "443", "--ports", "y", "--zones", "c", "--zones", "a"})
+	assert.Error(t, err)
+	assert.Equal(t, `invalid values for flag ports: "x": strconv.Atoi: parsing "x": invalid syntax; "y": strconv.Atoi: parsing "y": invalid syntax
+invalid values for flag zones: "c": "c" is not one of a, b`, err.Error())
+	assert.Equal(t, C{Ports: []int{80}}, c)
+
+	// the errors of a failed parse don't leak into the next one
+	_, err = fm.ParseArgs(&c, []string{"--ports", "443", "--ports", "8443"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{443, 8443}, c.Ports)
+
+	_, err = ParseArgs(&struct {
+		Port int `flag:",collect"`
+	}{}, nil)
+	assert.Error(t, err)
+
+	parseBareBool(t, func(v flag.Getter) flag.Value { return newCollectValue(v) })
+}
+
+type testColor int32
+
+// testColor_value mimics the maps generated along with protobuf enums.
+var testColor_value = map[string]int32{
+	"COLOR_UNSPECIFIED": 0,
+	"COLOR_RED":         1,
+	"COLOR_BLUE":        2,
+}
+
+func TestFlagMakerEnum(t *testing.T) {
+	type C struct {
+		Color testColor
+		Other *testColor
+		Count int32
+	}
+	fm := NewFlagMaker()
+	fm.RegisterEnum(testColor(0), testColor_value)
+	c := C{Color: 1}
+	_, err := fm.ParseArgs(&c, []string{"--other", "COLOR_BLUE", "--count", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, testColor(2), *c.Other)
+	assert.Equal(t, "COLOR_RED", fm.fs.Lookup("color").DefValue)
+	assert.Equal(t, int32(2), c.Count)
+
+	_, err = fm.ParseArgs(&c, []string{"--color", "COLOR_GREEN"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown value "COLOR_GREEN", valid values are COLOR_BLUE, COLOR_RED, COLOR_UNSPECIFIED`)
+	assert.Equal(t, testColor(1), c.Color)
+
+	infos, err := fm.Describe(&c)
+	assert.Nil(t, err)
+	assert.Equal(t, "flags.testColor", infos[0].Type)
+}
+
+func TestFlagMakerCatchall(t *testing.T) {
+	type C struct {
+		Name  string
+		Ext   struct{ Known string }
+		Extra map[string]string `flag:",catchall=ext."`
+	}
+	c := &C{}
+	fm := NewFlagMaker()
+	args, err := fm.ParseArgs(c, []string{"--ext.foo", "bar", "-ext.a=b", "--name", "x", "--ext.known", "k", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest"}, args)
+	assert.Equal(t, map[string]string{"foo": "bar", "a": "b"}, c.Extra)
+	assert.Equal(t, "x", c.Name)
+	assert.Equal(t, "k", c.Ext.Known)
+
+	c = &C{Extra: map[string]string{"old": "1"}}
+	old := c.Extra
+	fm = NewFlagMaker()
+	_, err = fm.ParseArgs(c, []string{"--ext.foo", "bar", "--name"})
+	assert.EqualError(t, err, "flag needs an argument: -name")
+	assert.Equal(t, map[string]string{"old": "1"}, c.Extra)
+	assert.Equal(t, map[string]string{"old": "1"}, old)
+
+	_, err = fm.ParseArgs(c, []string{"--other", "1"})
+	assert.EqualError(t, err, "flag provided but not defined: -other")
+	_, err = fm.ParseArgs(c, []string{"--ext.foo"})
+	assert.EqualError(t, err, "flag needs an argument: --ext.foo")
+	assert.Equal(t, map[string]string{"old": "1"}, c.Extra)
+
+	type bad struct {
+		Extra map[string]bool `flag:",catchall=ext."`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.EqualError(t, err, "catchall option is only supported for map[string]string, not for flag extra")
+}
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestFlagMakerResolvable(t *testing.T) {
+	type C struct {
+		Host  string   `flag:",resolvable"`
+		Peers []string `flag:",resolvable"`
+	}
+	resolver := stubResolver{"db.internal": {"10.0.0.1"}, "cache": {"10.0.0.2"}}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Resolver: resolver})
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--host", "db.internal", "--peers", "cache:11211", "--peers", "db.internal"})
+	assert.Nil(t, err)
+	assert.Equal(t, "db.internal", c.Host)
+	assert.Equal(t, []string{"cache:11211", "db.internal"}, c.Peers)
+
+	_, err = fm.ParseArgs(c, []string{"--host", "db.intenral"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot resolve")
+	assert.Equal(t, "db.internal", c.Host)
+
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Resolver: resolver, SkipLookups: true})
+	_, err = fm.ParseArgs(c, []string{"--host", "db.intenral"})
+	assert.Nil(t, err)
+	assert.Equal(t, "db.intenral", c.Host)
+
+	type bad struct {
+		Port int `flag:",resolvable"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerAlternateNames(t *testing.T) {
+	type C struct {
+		Network struct {
+			Timeout time.Duration `flag:"timeout|readtimeout|read-timeout"`
+		}
+	}
+	c := &C{}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(c, []string{"--network.readtimeout", "1s"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, c.Network.Timeout)
+
+	_, err = fm.ParseArgs(c, []string{"--network.read-timeout", "2s", "--network.timeout", "3s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Second, c.Network.Timeout)
+
+	infos, err := NewFlagMaker().Describe(&C{})
+	assert.Nil(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "network.timeout", infos[0].Name)
+	}
+
+	type clash struct {
+		A int `flag:"a|b"`
+		B int
+	}
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true}).ParseArgs(&clash{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerOverflow(t *testing.T) {
+	type C struct {
+		I   int
+		I8  int8
+		I16 int16
+		I32 int32
+		I64 int64
+		U   uint
+		U8  uint8
+		U16 uint16
+		U32 uint32
+		U64 uint64
+		Is  []int
+	}
+	tests := []struct {
+		args []string
+		msg  string
+	}{
+		{args: []string{"--i8", "300"}, msg: `invalid value "300" for flag -i8: value 300 overflows int8`},
+		{args: []string{"--i8", "-129"}, msg: `invalid value "-129" for flag -i8: value -129 overflows int8`},
+		{args: []string{"--i16", "40000"}, msg: `invalid value "40000" for flag -i16: value 40000 overflows int16`},
+		{args: []string{"--i32", "3000000000"}, msg: `invalid value "3000000000" for flag -i32: value 3000000000 overflows int32`},
+		{args: []string{"--u8", "256"}, msg: `invalid value "256" for flag -u8: value 256 overflows uint8`},
+		{args: []string{"--u16", "70000"}, msg: `invalid value "70000" for flag -u16: value 70000 overflows uint16`},
+		{args: []string{"--u32", "5000000000"}, msg: `invalid value "5000000000" for flag -u32: value 5000000000 overflows uint32`},
+		{args: []string{"--i", "1e30"}, msg: `invalid value "1e30" for flag -i: strconv.ParseInt: parsing "1e30": invalid syntax`},
+		{args: []string{"--i", "9223372036854775808"}, msg: `invalid value "9223372036854775808" for flag -i: value 9223372036854775808 overflows int64`},
+		{args: []string{"--i64", "-9223372036854775809"}, msg: `invalid value "-9223372036854775809" for flag -i64: value -9223372036854775809 overflows int64`},
+		{args: []string{"--u", "-1"}, msg: `invalid value "-1" for flag -u: strconv.ParseUint: parsing "-1": invalid syntax`},
+		{args: []string{"--u64", "18446744073709551616"}, msg: `invalid value "18446744073709551616" for flag -u64: value 18446744073709551616 overflows uint64`},
+		{args: []string{"--is", "1", "--is", "9223372036854775808"}, msg: `invalid value "9223372036854775808" for flag -is: value 9223372036854775808 overflows int64`},
+	}
+	for _, tt := range tests {
+		_, err := NewFlagMaker().ParseArgs(&C{}, tt.args)
+		if assert.Error(t, err, "%v", tt.args) {
+			assert.Equal(t, tt.msg, err.Error())
+		}
+	}
+
+	_, err := NewFlagMaker().ParseArgs(&C{}, []string{"--u8", "x"})
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "overflows")
+	}
+
+	// int and uint fields still take base prefixes
+	c := &C{}
+	_, err = NewFlagMaker().ParseArgs(c, []string{"--i", "0x10", "--u64", "0b101"})
+	assert.Nil(t, err)
+	assert.Equal(t, 16, c.I)
+	assert.Equal(t, uint64(5), c.U64)
+}
+
+func TestFlagMakerCount(t *testing.T) {
+	type C struct {
+		Verbosity int8 `yaml:"v" flag:",count"`
+		Name      string
+	}
+	c := &C{Verbosity: 1}
+	fm := NewFlagMaker()
+	args, err := fm.ParseArgs(c, []string{"-v", "-v", "--name", "x", "-v", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest"}, args)
+	assert.Equal(t, int8(3), c.Verbosity)
+
+	_, err = fm.ParseArgs(c, []string{"-v=5", "-v"})
+	assert.Nil(t, err)
+	assert.Equal(t, int8(6), c.Verbosity)
+
+	_, err = fm.ParseArgs(c, []string{"-v", "-v=false"})
+	assert.Nil(t, err)
+	assert.Equal(t, int8(0), c.Verbosity)
+
+	// 1 and 0 are counts rather than booleans
+	_, err = fm.ParseArgs(c, []string{"-v", "-v", "-v=1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int8(1), c.Verbosity)
+	_, err = fm.ParseArgs(c, []string{"-v=1", "-v"})
+	assert.Nil(t, err)
+	assert.Equal(t, int8(2), c.Verbosity)
+
+	_, err = fm.ParseArgs(c, []string{"-v=127", "-v"})
+	assert.Error(t, err)
+	_, err = fm.ParseArgs(c, []string{"-v=lots"})
+	assert.Error(t, err)
+
+	type bad struct {
+		Verbosity string `flag:",count"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerSetAllowed(t *testing.T) {
+	type C struct {
+		Plugin  string
+		Plugins []string
+	}
+	c := &C{}
+	fm := NewFlagMaker()
+	fm.SetAllowed("plugin", []string{"auth", "metrics"})
+	_, err := fm.ParseArgs(c, []string{"--plugin", "auth"})
+	assert.Nil(t, err)
+	assert.Equal(t, "auth", c.Plugin)
+
+	_, err = fm.ParseArgs(c, []string{"--plugin", "tracing"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `"tracing" is not one of auth, metrics`)
+	}
+	assert.Equal(t, "auth", c.Plugin)
+
+	fm.SetAllowed("plugin", []string{"auth", "metrics", "tracing"})
+	_, err = fm.ParseArgs(c, []string{"--plugin", "tracing"})
+	assert.Nil(t, err)
+	assert.Equal(t, "tracing", c.Plugin)
+
+	// restricting a flag already defined
+	fm.SetAllowed("plugins", []string{"auth"})
+	_, err = fm.ParseArgs(c, []string{"--plugins", "auth", "--plugins", "metrics"})
+	assert.Error(t, err)
+	assert.Empty(t, c.Plugins)
+}
+
+func TestFlagMakerClean(t *testing.T) {
+	type C struct {
+		Dir   string   `flag:",clean"`
+		Paths []string `flag:",clean"`
+		Root  string   `flag:",clean=abs"`
+	}
+	c := &C{}
+	_, err := NewFlagMaker().ParseArgs(c, []string{"--dir", "a/../b//c/", "--paths", "./x", "--paths", "/y/./z/..", "--root", "r/../s"})
+	assert.Nil(t, err)
+	assert.Equal(t, "b/c", c.Dir)
+	assert.Equal(t, []string{"x", "/y"}, c.Paths)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(wd, "s"), c.Root)
+
+	// empty values aren't paths
+	_, err = NewFlagMaker().ParseArgs(c, []string{"--dir=", "--root="})
+	assert.Nil(t, err)
+	assert.Equal(t, "", c.Dir)
+	assert.Equal(t, "", c.Root)
+
+	type bad struct {
+		Dir string `flag:",clean=rel"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerProfile(t *testing.T) {
+	type C struct {
+		Workers int
+		Memory  string
+		Hosts   []string
+		Debug   *bool
+		Limits  struct {
+			Rate int
+		}
+	}
+	debug := true
+	fm := NewFlagMaker()
+	fm.RegisterProfile("small", C{Workers: 1, Memory: "1G", Hosts: []string{"a"}})
+	large := &C{Workers: 16, Memory: "64G", Hosts: []string{"a", "b"}, Debug: &debug}
+	large.Limits.Rate = 100
+	fm.RegisterProfile("large", large)
+
+	c := &C{Workers: 4}
+	_, err := fm.ParseArgs(c, []string{"--memory", "32G", "--profile", "large", "--hosts", "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, 16, c.Workers)
+	assert.Equal(t, "32G", c.Memory)
+	assert.Equal(t, []string{"c"}, c.Hosts)
+	assert.Equal(t, 100, c.Limits.Rate)
+	if assert.NotNil(t, c.Debug) {
+		assert.True(t, *c.Debug)
+	}
+	// the preset is unchanged
+	assert.Equal(t, []string{"a", "b"}, large.Hosts)
+
+	_, err = fm.ParseArgs(c, []string{"--profile", "medium"})
+	assert.Error(t, err)
+
+	type other struct{ Workers int }
+	fm = NewFlagMaker()
+	fm.RegisterProfile("small", other{Workers: 1})
+	_, err = fm.ParseArgs(&C{}, []string{"--profile", "small"})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerErrorFormatter(t *testing.T) {
+	type C struct {
+		Port  int
+		Hosts []string `flag:",maxbytes=4"`
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{
+		UseLowerCase: true,
+		ErrorFormatter: func(name, value string, kind reflect.Kind, err error) error {
+			return fmt.Errorf("--%s attend un %v, pas %q", name, kind, value)
+		},
+	})
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--port", "eighty"})
+	if assert.Error(t, err) {
+		assert.Equal(t, `--port attend un int, pas "eighty"`, err.Error())
+	}
+	_, err = fm.ParseArgs(c, []string{"--hosts", "localhost"})
+	if assert.Error(t, err) {
+		assert.Equal(t, `--hosts attend un slice, pas "localhost"`, err.Error())
+	}
+	_, err = fm.ParseArgs(c, []string{"--unknown"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "flag provided but not defined")
+	}
+	err = fm.ParseEnviron(c, "", []string{"PORT=x"})
+	if assert.Error(t, err) {
+		assert.Equal(t, `--port attend un int, pas "x"`, err.Error())
+	}
+
+	_, err = NewFlagMaker().ParseArgs(c, []string{"--port", "eighty"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid value "eighty" for flag -port`)
+	}
+}
+
+func TestFlagMakerRGBA(t *testing.T) {
+	type C struct {
+		Theme struct {
+			Background color.RGBA
+			Overlay    color.RGBA
+		}
+	}
+	c := &C{}
+	fm := NewFlagMaker()
+	_, err := fm.ParseArgs(c, []string{"--theme.background", "#112233", "--theme.overlay", "#11223344"})
+	assert.Nil(t, err)
+	assert.Equal(t, color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}, c.Theme.Background)
+	assert.Equal(t, color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0x44}, c.Theme.Overlay)
+	f := fm.fs.Lookup("theme.overlay")
+	assert.Equal(t, "#11223344", f.Value.String())
+	assert.Equal(t, c.Theme.Overlay, f.Value.(flag.Getter).Get())
+
+	for _, bad := range []string{"112233", "#1122", "#11223g", "#1122334455"} {
+		_, err = fm.ParseArgs(c, []string{"--theme.background", bad})
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}, c.Theme.Background)
+}
+
+func TestFlagMakerSetAllowedSlice(t *testing.T) {
+	type C struct {
+		Plugins []string
+	}
+	fm := NewFlagMaker()
+	c := &C{Plugins: []string{"auth"}}
+	_, err := fm.ParseArgs(c, nil)
+	assert.Nil(t, err)
+	// the plugins are only known once loaded
+	fm.SetAllowed("plugins", []string{"auth", "metrics", "tracing"})
+
+	_, err = fm.ParseArgs(c, []string{"--plugins", "metrics", "--plugins", "tracing"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"metrics", "tracing"}, c.Plugins)
+
+	_, err = fm.ParseArgs(c, []string{"--plugins", "auth", "--plugins", "logging", "--plugins", "metrics"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `"logging" is not one of auth, metrics, tracing`)
+	}
+	assert.Equal(t, []string{"metrics", "tracing"}, c.Plugins)
+
+	err = fm.ParseEnviron(c, "", []string{"PLUGINS=auth,logging"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `"logging" is not one of auth, metrics, tracing`)
+	}
+	assert.Equal(t, []string{"metrics", "tracing"}, c.Plugins)
+
+	// an empty value still clears the field
+	_, err = fm.ParseArgs(c, []string{"--plugins="})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, c.Plugins)
+	// the values go through the checks of the field first
+	type checked struct {
+		Modes []string `flag:",oneof=dev test prod"`
+	}
+	d := &checked{Modes: []string{"dev"}}
+	fm = NewFlagMaker()
+	_, err = fm.ParseArgs(d, nil)
+	assert.Nil(t, err)
+	fm.SetAllowed("modes", []string{"dev", "prod"})
+	_, err = fm.ParseArgs(d, []string{"--modes", "test"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `"test" is not one of dev, prod`)
+	}
+	_, err = fm.ParseArgs(d, []string{"--modes=", "--modes", "prod"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"prod"}, d.Modes)
+}
+
+func TestFlagMakerCut(t *testing.T) {
+	type C struct {
+		Path   string   `flag:",cut=/"`
+		Quoted []string `flag:",cut=\"'"`
+		Port   int      `flag:",cut=/"`
+	}
+	c := &C{}
+	_, err := NewFlagMaker().ParseArgs(c, []string{"--path", "//path/to/", "--quoted", `"a"`, "--quoted", "'b'", "--port", "80"})
+	assert.Nil(t, err)
+	assert.Equal(t, "path/to", c.Path)
+	assert.Equal(t, []string{"a", "b"}, c.Quoted)
+	assert.Equal(t, 80, c.Port)
+}
+
+func TestFlagMakerUnsupported(t *testing.T) {
+	type C struct {
+		Level   int
+		Handle  uintptr
+		Ptr     unsafe.Pointer
+		Events  chan int
+		Hook    func()
+		Sizes   map[string]int
+		Weights []float32
+		Phase   complex128
+	}
+	fm := NewFlagMaker()
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--level", "3"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Level)
+	assert.Equal(t, map[string]string{
+		"handle":  "fields of type uintptr are not supported",
+		"ptr":     "fields of type unsafe.Pointer are not supported",
+		"events":  "fields of type chan int are not supported",
+		"hook":    "fields of type func() are not supported",
+		"sizes":   "fields of type map[string]int are not supported",
+		"weights": "fields of type []float32 are not supported",
+		"phase":   "fields of type complex128 are not supported",
+	}, fm.Unsupported())
+
+	_, err = fm.ParseArgs(c, []string{"--handle", "1"})
+	assert.Error(t, err)
+}
+
+func TestFlagMakerJSONTagNames(t *testing.T) {
+	type C struct {
+		Network struct {
+			ReadTimeout time.Duration `json:"tcp.readtimeout,omitempty"`
+			Host        string        `json:",omitempty"`
+			Port        int           `json:"port"`
+		} `json:"network"`
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, TagName: "json"})
+	var c C
+	_, err := fm.ParseArgs(&c, []string{"--network.tcp.readtimeout", "5ms", "--network.host", "h", "--network.port", "80"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Millisecond, c.Network.ReadTimeout)
+	assert.Equal(t, "h", c.Network.Host)
+	assert.Equal(t, 80, c.Network.Port)
+
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, TagName: "json", JSONPointer: true})
+	_, err = fm.ParseArgs(&c, []string{"--/network/tcp/readtimeout", "7ms"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7*time.Millisecond, c.Network.ReadTimeout)
+}
+
+func TestFlagMakerBindSyncMap(t *testing.T) {
+	var m sync.Map
+	m.Store("workers", 4)
+	m.Store("name", "svc")
+	schema := map[string]reflect.Kind{
+		"workers":   reflect.Int,
+		"name":      reflect.String,
+		"debug":     reflect.Bool,
+		"db.weight": reflect.Float64,
+	}
+	fm := NewFlagMaker()
+	left, err := fm.BindSyncMap(&m, schema, []string{"--workers", "8", "--debug", "--db.weight", "0.5", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest"}, left)
+	load := func(key string) interface{} {
+		v, _ := m.Load(key)
+		return v
+	}
+	assert.Equal(t, 8, load("workers"))
+	assert.Equal(t, true, load("debug"))
+	assert.Equal(t, 0.5, load("db.weight"))
+	assert.Equal(t, "svc", load("name"))
+
+	_, err = fm.BindSyncMap(&m, schema, []string{"--name", "other", "--workers", "x"})
+	assert.Error(t, err)
+	assert.Equal(t, "svc", load("name"))
+	assert.Equal(t, 8, load("workers"))
+
+	_, err = fm.BindSyncMap(&m, map[string]reflect.Kind{"hosts": reflect.Slice}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerSources(t *testing.T) {
+	type C struct {
+		X int    `flag:",source=flag,env=FLAGS_TEST_X,file=flags_test_x,default=5"`
+		Y string `flag:",env=FLAGS_TEST_Y,source=flag"`
+	}
+	// the file source is relative to the working directory
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	fm := NewFlagMaker()
+	c := &C{}
+	_, err = fm.ParseArgs(c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, c.X)
+	assert.Equal(t, "", c.Y)
+
+	assert.Nil(t, os.WriteFile("flags_test_x", []byte("6\n"), 0o600))
+	_, err = fm.ParseArgs(c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, c.X)
+
+	t.Setenv("FLAGS_TEST_X", "7")
+	t.Setenv("FLAGS_TEST_Y", "env")
+	_, err = fm.ParseArgs(c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, c.X)
+	assert.Equal(t, "env", c.Y)
+
+	// the arguments come first for X, last for Y
+	_, err = fm.ParseArgs(c, []string{"--x", "8", "--y", "arg"})
+	assert.Nil(t, err)
+	assert.Equal(t, 8, c.X)
+	assert.Equal(t, "env", c.Y)
+
+	t.Setenv("FLAGS_TEST_X", "seven")
+	_, err = fm.ParseArgs(c, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 8, c.X)
+
+	type bad struct {
+		X int `flag:",source=file"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFlagMakerDupKeys(t *testing.T) {
+	type C struct {
+		Last  map[string]bool
+		First map[string]bool `flag:",dupkeys=first"`
+		Error map[string]bool `flag:",dupkeys=error"`
+	}
+	fm := NewFlagMaker()
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--last", "a=true", "--last", "a=false", "--first", "a=true", "--first", "a=false"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"a": false}, c.Last)
+	assert.Equal(t, map[string]bool{"a": true}, c.First)
+
+	_, err = fm.ParseArgs(c, []string{"--error", "a=true", "--error", "b", "--error", "a=false"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `duplicate key "a"`)
+	}
+	assert.Nil(t, c.Error)
+
+	// keys only clash within a parse
+	_, err = fm.ParseArgs(c, []string{"--error", "a=true"})
+	assert.Nil(t, err)
+	_, err = fm.ParseArgs(c, []string{"--error", "a=false"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"a": false}, c.Error)
+
+	// likewise for string maps
+	type last struct {
+		Env map[string]string
+	}
+	type first struct {
+		Env map[string]string `flag:",dupkeys=first"`
+	}
+	type fail struct {
+		Env map[string]string `flag:",dupkeys=error"`
+	}
+	env := []string{"--env", "a=1", "--env", "a=2"}
+	l := &last{}
+	_, err = NewFlagMaker().ParseArgs(l, env)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "2"}, l.Env)
+
+	f := &first{}
+	_, err = NewFlagMaker().ParseArgs(f, env)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, f.Env)
+
+	e := &fail{}
+	_, err = NewFlagMaker().ParseArgs(e, env)
+	assert.EqualError(t, err, `invalid value "a=2" for flag -env: duplicate key "a"`)
+	assert.Nil(t, e.Env)
+
+	type bad struct {
+		M map[string]bool `flag:",dupkeys=merge"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+	_, err = NewFlagMaker().ParseArgs(&struct {
+		M map[string]string `flag:",dupkeys=merge"`
+	}{}, nil)
+	assert.EqualError(t, err, `invalid dupkeys option "merge" for flag m`)
+}
+
+func TestFlagMakerRanges(t *testing.T) {
+	type C struct {
+		Ports   []int `flag:",ranges"`
+		Offsets []int `flag:",ranges"`
+	}
+	fm := NewFlagMaker()
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--ports", "8000-8002", "--ports", "80", "--ports", "443,9000-9001", "--offsets", "-2-1,-5"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{8000, 8001, 8002, 80, 443, 9000, 9001}, c.Ports)
+	assert.Equal(t, []int{-2, -1, 0, 1, -5}, c.Offsets)
+
+	for _, bad := range []string{"8002-8000", "80-", "a-b", "1-2-3", "0-100000", "x"} {
+		_, err = fm.ParseArgs(c, []string{"--ports", bad})
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, []int{8000, 8001, 8002, 80, 443, 9000, 9001}, c.Ports)
+
+	type bad struct {
+		Names []string `flag:",ranges"`
+	}
+	_, err = NewFlagMaker().ParseArgs(&bad{}, nil)
+	assert.Error(t, err)
+}
+
+type testDSN struct {
+	User string
+	Host string
+	Port int
+	DB   string
+}
+
+func (d testDSN) String() string {
+	return fmt.Sprintf("%s@%s:%d/%s", d.User, d.Host, d.Port, d.DB)
+}
+
+// decodeTestDSN parses user@host:port/db.
+func decodeTestDSN(s string, v reflect.Value) error {
+	var d testDSN
+	at := strings.Index(s, "@")
+	slash := strings.LastIndex(s, "/")
+	if at < 0 || slash < at {
+		return fmt.Errorf("invalid DSN %q", s)
+	}
+	host, port, err := net.SplitHostPort(s[at+1 : slash])
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Sscan(port, &d.Port); err != nil {
+		return err
+	}
+	d.User, d.Host, d.DB = s[:at], host, s[slash+1:]
+	v.Set(reflect.ValueOf(d))
+	return nil
+}
+
+func TestFlagMakerRegisterCodec(t *testing.T) {
+	type C struct {
+		Primary testDSN
+		Replica *testDSN
+		Name    string
+	}
+	fm := NewFlagMaker()
+	fm.RegisterCodec(reflect.TypeOf(testDSN{}), decodeTestDSN)
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--primary", "app@db1:5432/orders", "--replica", "ro@db2:5433/orders"})
+	assert.Nil(t, err)
+	assert.Equal(t, testDSN{User: "app", Host: "db1", Port: 5432, DB: "orders"}, c.Primary)
+	if assert.NotNil(t, c.Replica) {
+		assert.Equal(t, testDSN{User: "ro", Host: "db2", Port: 5433, DB: "orders"}, *c.Replica)
+	}
+	assert.Equal(t, "app@db1:5432/orders", fm.fs.Lookup("primary").Value.String())
+	assert.Nil(t, fm.fs.Lookup("primary.host"))
+
+	_, err = fm.ParseArgs(c, []string{"--primary", "db1"})
+	assert.Error(t, err)
+	assert.Equal(t, "db1", c.Primary.Host)
+}
+
+func TestFlagMakerEmptyValues(t *testing.T) {
+	type C struct {
+		Name    string
+		Hosts   []string
+		Ports   []int `flag:",append"`
+		Enabled map[string]bool
+		Backups *[]string
+	}
+	fm := NewFlagMaker()
+	c := &C{
+		Name:    "db",
+		Hosts:   []string{"a", "b"},
+		Ports:   []int{80},
+		Enabled: map[string]bool{"x": true},
+	}
+	args, err := fm.ParseArgs(c, []string{"--name=", "--hosts=", "--ports=", "--enabled=", "--backups="})
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, &C{
+		Hosts:   []string{},
+		Ports:   []int{},
+		Enabled: map[string]bool{},
+		Backups: &[]string{},
+	}, c)
+
+	fm = NewFlagMaker()
+	c = &C{Hosts: []string{"a"}, Ports: []int{80}}
+	_, err = fm.ParseArgs(c, []string{"--hosts", "b", "--hosts=", "--hosts", "c", "--ports=", "--ports", "443"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, c.Hosts)
+	assert.Equal(t, []int{443}, c.Ports)
+
+	_, err = fm.ParseArgs(c, []string{"--hosts", ""})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, c.Hosts)
+
+	var flags []bool
+	parseBareBool(t, func(v flag.Getter) flag.Value {
+		return newClearValue(v, reflect.ValueOf(&flags).Elem())
+	})
+}
+
+func TestFlagMakerParseArgsCopy(t *testing.T) {
+	type tls struct {
+		Cert string
+	}
+	type C struct {
+		Name   string
+		Hosts  []string
+		Labels map[string]bool
+		TLS    *tls
+		Extra  map[string][]int `yaml:"-"`
+	}
+	orig := &C{
+		Name:   "db",
+		Hosts:  []string{"a"},
+		Labels: map[string]bool{"x": true},
+		TLS:    &tls{Cert: "old.pem"},
+		Extra:  map[string][]int{"k": {1}},
+	}
+	fm := NewFlagMaker()
+	c, args, err := fm.ParseArgsCopy(orig, []string{"--name", "cache", "--hosts", "b", "--labels", "y", "--tls.cert", "new.pem", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest"}, args)
+	assert.Equal(t, &C{
+		Name:   "cache",
+		Hosts:  []string{"b"},
+		Labels: map[string]bool{"y": true},
+		TLS:    &tls{Cert: "new.pem"},
+		Extra:  map[string][]int{"k": {1}},
+	}, c)
+	assert.Equal(t, &C{
+		Name:   "db",
+		Hosts:  []string{"a"},
+		Labels: map[string]bool{"x": true},
+		TLS:    &tls{Cert: "old.pem"},
+		Extra:  map[string][]int{"k": {1}},
+	}, orig)
+
+	copied := c.(*C)
+	copied.Extra["k"][0] = 2
+	assert.Equal(t, 1, orig.Extra["k"][0])
+
+	// the FlagMaker isn't bound to either object
+	c, _, err = fm.ParseArgsCopy(orig, []string{"--name", "queue"})
+	assert.Nil(t, err)
+	assert.Equal(t, "queue", c.(*C).Name)
+	assert.Equal(t, "db", orig.Name)
+
+	_, _, err = fm.ParseArgsCopy(orig, []string{"--hosts"})
+	assert.Error(t, err)
+	assert.Equal(t, "db", orig.Name)
+}
+
+// heldLocker records whether it's held and how many times it was locked.
+type heldLocker struct {
+	held  bool
+	locks int
+}
+
+func (l *heldLocker) Lock() {
+	l.held = true
+	l.locks++
+}
+
+func (l *heldLocker) Unlock() { l.held = false }
+
+func TestFlagMakerParseArgsCopyLocker(t *testing.T) {
+	type C struct {
+		Name string
+	}
+	l := &heldLocker{}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Locker: l})
+	fm.AddCrossValidator(func(obj interface{}) error {
+		// the copy is parsed without the lock
+		assert.False(t, l.held)
+		return nil
+	})
+	c, _, err := fm.ParseArgsCopy(&C{Name: "db"}, []string{"--name", "queue"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Name: "queue"}, c)
+	// it's only held while copying
+	assert.Equal(t, 1, l.locks)
+
+	_, err = fm.Preview(&C{Name: "db"}, []string{"--name", "queue"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, l.locks)
+	assert.False(t, l.held)
+}
+
+func TestFlagMakerDashAliases(t *testing.T) {
+	type tcp struct {
+		ReadTimeout time.Duration
+	}
+	type network struct {
+		TCP tcp
+	}
+	type C struct {
+		Network network
+		Name    string
+	}
+	opts := &FlagMakingOptions{UseLowerCase: true, DashAliases: true}
+
+	c := &C{}
+	fm := NewFlagMakerAdv(opts)
+	_, err := fm.ParseArgs(c, []string{"-network-tcp-readtimeout", "5s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, c.Network.TCP.ReadTimeout)
+	_, err = fm.ParseArgs(c, []string{"--network.tcp.readtimeout", "7s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7*time.Second, c.Network.TCP.ReadTimeout)
+
+	infos, err := NewFlagMakerAdv(opts).Describe(&C{})
+	assert.Nil(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"name", "network.tcp.readtimeout"}, names)
+}
+
+func TestFlagMakerStrictRepeats(t *testing.T) {
+	type C struct {
+		Name  string `flag:"name|n"`
+		Hosts []string
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, StrictRepeats: true})
+	c := &C{Name: "db"}
+	_, err := fm.ParseArgs(c, []string{"--name", "a", "--hosts", "x", "--hosts", "y"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Name: "a", Hosts: []string{"x", "y"}}, c)
+
+	_, err = fm.ParseArgs(c, []string{"--name", "b", "-n", "c"})
+	assert.EqualError(t, err, "flag name is given more than once")
+	assert.Equal(t, "a", c.Name)
+
+	_, err = NewFlagMaker().ParseArgs(c, []string{"--name", "b", "--name", "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, "c", c.Name)
+}
+
+type appliedLog []string
+
+func (l *appliedLog) Applied(name, oldValue, newValue string) {
+	*l = append(*l, fmt.Sprintf("%s: %s -> %s", name, oldValue, newValue))
+}
+
+func TestFlagMakerLogger(t *testing.T) {
+	type C struct {
+		Name     string
+		Port     int
+		Hosts    []string
+		Password string `flag:",secret"`
+	}
+	var log appliedLog
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Logger: &log})
+	c := &C{Name: "db", Port: 80}
+	_, err := fm.ParseArgs(c, []string{"--name", "db", "--port", "81", "--hosts", "a", "--password", "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, appliedLog{
+		"hosts: [] -> [a]",
+		"password: **** -> ****",
+		"port: 80 -> 81",
+	}, log)
+
+	log = nil
+	_, err = fm.ParseArgs(c, []string{"--port", "82", "--hosts", "x", "--port", "y"})
+	assert.Error(t, err)
+	assert.Empty(t, log)
+
+	assert.Nil(t, fm.ParseEnviron(c, "app", []string{"APP_NAME=cache"}))
+	assert.Equal(t, appliedLog{"name: db -> cache"}, log)
+}
+
+func TestFlagMakerLocker(t *testing.T) {
+	type C struct {
+		Host string
+		Port int
+	}
+	var mu sync.RWMutex
+	c := &C{Host: "a", Port: 1}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Locker: &mu})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				mu.RLock()
+				host, port := c.Host, c.Port
+				mu.RUnlock()
+				// the host and port are always updated together
+				if host != string(rune('a'+port-1)) {
+					t.Errorf("torn read: %s %d", host, port)
+					return
+				}
+			}
+		}()
+	}
+	for port := 1; port <= 26; port++ {
+		_, err := fm.ParseArgs(c, []string{"--host", string(rune('a' + port - 1)), "--port", fmt.Sprint(port)})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, fm.ParseEnviron(c, "app", []string{"APP_HOST=b", "APP_PORT=2"}))
+	close(done)
+	wg.Wait()
+	assert.Equal(t, &C{Host: "b", Port: 2}, c)
+}
+
+// readLog logs the overrides along with a value read through the lock of the
+// config, as a Logger of a shared config would.
+type readLog struct {
+	mu   *sync.RWMutex
+	read func() string
+	log  []string
+}
+
+func (l *readLog) Applied(name, oldValue, newValue string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	l.log = append(l.log, fmt.Sprintf("%s: %s -> %s (%s)", name, oldValue, newValue, l.read()))
+}
+
+func TestFlagMakerLockerLogger(t *testing.T) {
+	type C struct {
+		Host string
+	}
+	var mu sync.RWMutex
+	c := &C{Host: "a"}
+	l := &readLog{mu: &mu, read: func() string { return c.Host }}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Locker: &mu, Logger: l})
+	_, err := fm.ParseArgs(c, []string{"--host", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, fm.ParseEnviron(c, "app", []string{"APP_HOST=c"}))
+	assert.Equal(t, []string{"host: a -> b (b)", "host: b -> c (c)"}, l.log)
+	assert.Equal(t, map[string]string{"host": "env"}, fm.Provenance())
+}
+
+func TestFlagMakerMapSlice(t *testing.T) {
+	type C struct {
+		Rules []map[string]string
+	}
+	fm := NewFlagMaker()
+	c := &C{Rules: []map[string]string{{"old": "x"}}}
+	_, err := fm.ParseArgs(c, []string{"--rules", "k1=v1,k2=v2", "--rules", "k1=v3,k3="})
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]string{
+		{"k1": "v1", "k2": "v2"},
+		{"k1": "v3", "k3": ""},
+	}, c.Rules)
+	f := fm.fs.Lookup("rules")
+	assert.Equal(t, c.Rules, f.Value.(flag.Getter).Get())
+	assert.Equal(t, []string{"--rules=k1=v1,k2=v2", "--rules=k1=v3,k3="}, fm.ToArgs(c))
+
+	for _, arg := range []string{"k1", "k1=v1,", "=v1"} {
+		_, err = fm.ParseArgs(c, []string{"--rules", arg})
+		assert.Error(t, err, arg)
+	}
+	assert.Len(t, c.Rules, 2)
+}
+
+func TestFlagMakerUnsetToken(t *testing.T) {
+	type C struct {
+		Count   int
+		Name    string
+		Verbose bool
+		Timeout time.Duration
+		Limit   *int
+		Hosts   []string
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, UnsetToken: "unset"})
+	c := &C{Count: 5, Name: "db", Verbose: true, Timeout: time.Second}
+	_, err := fm.ParseArgs(c, []string{"--count=unset", "--name", "unset", "--verbose=unset", "--timeout", "unset", "--limit", "unset", "--hosts", "unset"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Hosts: []string{"unset"}}, c)
+
+	_, err = fm.ParseArgs(c, []string{"--count", "3", "--limit", "10"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Count)
+	limit := 10
+	assert.Equal(t, &limit, c.Limit)
+
+	// pointers set before the flags are defined are unset as well
+	limit = 20
+	c = &C{Limit: &limit}
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, UnsetToken: "unset"})
+	_, err = fm.ParseArgs(c, []string{"--limit", "unset"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Limit)
+	assert.Equal(t, 20, limit)
+	assert.NotContains(t, fm.ToArgs(c), "--limit=0")
+
+	// a failed parse restores them
+	c.Limit = &limit
+	_, err = fm.ParseArgs(c, []string{"--limit", "unset", "--count", "x"})
+	assert.Error(t, err)
+	assert.True(t, c.Limit == &limit)
+	assert.Equal(t, 20, limit)
+
+	c2 := &C{Count: 5}
+	_, err = NewFlagMaker().ParseArgs(c2, []string{"--count=unset"})
+	assert.Error(t, err)
+	assert.Equal(t, 5, c2.Count)
+}
+
+func TestFlagMakerPreview(t *testing.T) {
+	type C struct {
+		Name    string
+		Port    int
+		Hosts   []string
+		Timeout time.Duration
+	}
+	fm := NewFlagMaker()
+	c := &C{Name: "db", Port: 80, Hosts: []string{"a"}}
+	changes, err := fm.Preview(c, []string{"--name", "db", "--port", "81", "--hosts", "b", "--timeout", "5s"})
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Name: "hosts", Old: "[a]", New: "[b]"},
+		{Name: "port", Old: "80", New: "81"},
+		{Name: "timeout", Old: "0s", New: "5s"},
+	}, changes)
+	assert.Equal(t, &C{Name: "db", Port: 80, Hosts: []string{"a"}}, c)
+
+	changes, err = fm.Preview(c, []string{"--port", "x"})
+	assert.Error(t, err)
+	assert.Nil(t, changes)
+
+	// the FlagMaker can still parse the object afterwards
+	_, err = fm.ParseArgs(c, []string{"--port", "82"})
+	assert.Nil(t, err)
+	assert.Equal(t, 82, c.Port)
+}
+
+func TestFlagMakerValueSeparator(t *testing.T) {
+	type C struct {
+		Name    string
+		Addr    string
+		Port    int
+		Verbose bool
+		Timeout time.Duration
+		Hosts   []string
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, ValueSeparator: ":"})
+	c := &C{}
+	args, err := fm.ParseArgs(c, []string{
+		"--name:db", "-port:80", "--verbose:true", "--timeout:5s", "--hosts:a", "--hosts", "b:c",
+		"--addr", "--x:y", "rest", "--name:other",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest", "--name:other"}, args)
+	assert.Equal(t, &C{
+		Name:    "db",
+		Addr:    "--x:y",
+		Port:    80,
+		Verbose: true,
+		Timeout: 5 * time.Second,
+		Hosts:   []string{"a", "b:c"},
+	}, c)
+
+	_, err = fm.ParseArgs(c, []string{"--port:x"})
+	assert.Error(t, err)
+	_, err = NewFlagMaker().ParseArgs(&C{}, []string{"--name:db"})
+	assert.Error(t, err)
+}
+
+func TestCamelWords(t *testing.T) {
+	cases := map[string][]string{
+		"HTTPPort":    {"http", "port"},
+		"DBName":      {"db", "name"},
+		"XMLID":       {"xml", "id"},
+		"Name":        {"name"},
+		"ReadTimeout": {"read", "timeout"},
+		"UserID":      {"user", "id"},
+		"ABCDef":      {"abc", "def"},
+		"ABC":         {"abc"},
+		"UTF8Name":    {"utf8", "name"},
+		"max_conns":   {"max_conns"},
+	}
+	for name, words := range cases {
+		assert.Equal(t, words, camelWords(name), name)
+	}
+}
+
+func TestFlagMakerNameStyle(t *testing.T) {
+	type server struct {
+		HTTPPort int
+		XMLID    string
+		Name     string `yaml:"ServerName"`
+	}
+	type C struct {
+		DBName string
+		Server server
+	}
+	for style, names := range map[NameStyle][]string{
+		KebabCase: {"db-name", "server.ServerName", "server.http-port", "server.xml-id"},
+		SnakeCase: {"db_name", "server.ServerName", "server.http_port", "server.xml_id"},
+	} {
+		infos, err := NewFlagMakerAdv(&FlagMakingOptions{TagName: "yaml", NameStyle: style}).Describe(&C{})
+		assert.Nil(t, err)
+		var got []string
+		for _, info := range infos {
+			got = append(got, info.Name)
+		}
+		assert.Equal(t, names, got)
+	}
+
+	c := &C{}
+	_, err := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, NameStyle: KebabCase}).ParseArgs(c, []string{"--server.http-port", "80", "--db-name", "users"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{DBName: "users", Server: server{HTTPPort: 80}}, c)
+}
+
+func TestFlagMakerMustExist(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	assert.Nil(t, os.WriteFile(file, nil, 0o600))
+	missing := filepath.Join(dir, "missing")
+
+	type C struct {
+		Path    string   `flag:",mustexist"`
+		Dir     string   `flag:",mustexist=dir"`
+		Conf    string   `flag:",mustexist=file"`
+		Plugins []string `flag:",mustexist"`
+	}
+	fm := NewFlagMaker()
+	c := &C{}
+	_, err := fm.ParseArgs(c, []string{"--path", file, "--dir", dir, "--conf", file, "--plugins", dir, "--plugins", file})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{Path: file, Dir: dir, Conf: file, Plugins: []string{dir, file}}, c)
+
+	for _, args := range [][]string{
+		{"--path", missing},
+		{"--dir", file},
+		{"--conf", dir},
+		{"--plugins", file, "--plugins", missing},
+	} {
+		_, err := fm.ParseArgs(c, args)
+		assert.Error(t, err, args)
+	}
+	assert.Equal(t, &C{Path: file, Dir: dir, Conf: file, Plugins: []string{dir, file}}, c)
+
+	// the paths can be looked up elsewhere, or not at all
+	fm = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, Stat: func(name string) (os.FileInfo, error) {
+		return os.Stat(filepath.Join(dir, name))
+	}})
+	_, err = fm.ParseArgs(&C{}, []string{"--conf", "app.conf"})
+	assert.Nil(t, err)
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, SkipStats: true}).ParseArgs(&C{}, []string{"--path", missing})
+	assert.Nil(t, err)
+
+	_, err = NewFlagMaker().ParseArgs(&struct {
+		Path string `flag:",mustexist=link"`
+	}{}, nil)
+	assert.EqualError(t, err, `invalid mustexist option "link" for flag path`)
+
+	// the options are checked even when the stats or lookups are skipped
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, SkipStats: true}).ParseArgs(&struct {
+		Path string `flag:",mustexist=link"`
+	}{}, nil)
+	assert.EqualError(t, err, `invalid mustexist option "link" for flag path`)
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, SkipStats: true}).ParseArgs(&struct {
+		Port int `flag:",mustexist"`
+	}{}, nil)
+	assert.EqualError(t, err, "mustexist option is only supported for strings, not for flag port")
+	_, err = NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, SkipLookups: true}).ParseArgs(&struct {
+		Port int `flag:",resolvable"`
+	}{}, nil)
+	assert.EqualError(t, err, "resolvable option is only supported for strings, not for flag port")
+}
+
+func TestFlagMakerRegisterFinalizer(t *testing.T) {
+	type endpoint struct {
+		Host string
+		Port int
+		addr string
+	}
+	type C struct {
+		Primary  endpoint
+		Replicas []endpoint
+		summary  string
+	}
+	fm := NewFlagMaker()
+	fm.RegisterFinalizer(reflect.TypeOf(endpoint{}), func(v reflect.Value) error {
+		e := v.Addr().Interface().(*endpoint)
+		if e.Port == 0 {
+			return fmt.Errorf("no port for %s", e.Host)
+		}
+		e.addr = net.JoinHostPort(e.Host, fmt.Sprint(e.Port))
+		return nil
+	})
+	// the endpoints are finalized before the struct holding them
+	fm.RegisterFinalizer(reflect.TypeOf(C{}), func(v reflect.Value) error {
+		c := v.Addr().Interface().(*C)
+		c.summary = c.Primary.addr
+		for _, r := range c.Replicas {
+			c.summary += " " + r.addr
+		}
+		return nil
+	})
+	c := &C{Primary: endpoint{Port: 5432}, Replicas: []endpoint{{Port: 5432}}}
+	_, err := fm.ParseArgs(c, []string{"--primary.host", "db1", "--replicas.0.host", "db2", "--replicas.0.port", "5433"})
+	assert.Nil(t, err)
+	assert.Equal(t, "db1:5432", c.Primary.addr)
+	assert.Equal(t, "db2:5433", c.Replicas[0].addr)
+	assert.Equal(t, "db1:5432 db2:5433", c.summary)
+
+	_, err = fm.ParseArgs(c, []string{"--primary.host", "db3", "--primary.port", "0"})
+	assert.EqualError(t, err, "finalizing flags.endpoint: no port for db3")
+	assert.Equal(t, "db1", c.Primary.Host)
+	assert.Equal(t, 5432, c.Primary.Port)
+
+	// the spare elements of grown slices are only finalized once grown to
+	type pool struct {
+		Endpoints []endpoint `flag:",grow,maxlen=3"`
+	}
+	fm = NewFlagMaker()
+	fm.RegisterFinalizer(reflect.TypeOf(endpoint{}), func(v reflect.Value) error {
+		if v.FieldByName("Port").Int() == 0 {
+			return fmt.Errorf("no port")
+		}
+		return nil
+	})
+	p := &pool{Endpoints: []endpoint{{Host: "a", Port: 1}}}
+	_, err = fm.ParseArgs(p, []string{"--endpoints.0.host", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, []endpoint{{Host: "b", Port: 1}}, p.Endpoints)
+
+	_, err = fm.ParseArgs(p, []string{"--endpoints.1.host", "c"})
+	assert.EqualError(t, err, "finalizing flags.endpoint: no port")
+	assert.Equal(t, []endpoint{{Host: "b", Port: 1}}, p.Endpoints)
+
+	_, err = fm.ParseArgs(p, []string{"--endpoints.1.host", "c", "--endpoints.1.port", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []endpoint{{Host: "b", Port: 1}, {Host: "c", Port: 2}}, p.Endpoints)
+}
+
+func TestFlagMakerJSONArray(t *testing.T) {
+	type C struct {
+		Hosts   []string  `flag:",jsonarray"`
+		Ports   []int     `flag:",jsonarray"`
+		Weights []float64 `flag:",jsonarray"`
+	}
+	fm := NewFlagMaker()
+	c := &C{Hosts: []string{"old"}, Ports: []int{1}, Weights: []float64{1}}
+	_, err := fm.ParseArgs(c, []string{"--hosts", `["a", "b,c", "d\"e"]`, "--ports", "[80, 443]", "--ports", "[8080]", "--weights", "[]"})
+	assert.Nil(t, err)
+	assert.Equal(t, &C{
+		Hosts:   []string{"a", "b,c", `d"e`},
+		Ports:   []int{80, 443, 8080},
+		Weights: []float64{},
+	}, c)
+
+	for _, args := range [][]string{
+		{"--hosts", `["a"`},
+		{"--hosts", "a"},
+		{"--ports", `[80, "x"]`},
+		{"--ports", "[1.5]"},
+	} {
+		_, err := fm.ParseArgs(c, args)
+		assert.Error(t, err, args)
+	}
+	assert.Equal(t, []int{80, 443, 8080}, c.Ports)
+
+	_, err = NewFlagMaker().ParseArgs(&struct {
+		Name string `flag:",jsonarray"`
+	}{}, nil)
+	assert.EqualError(t, err, "jsonarray option is only supported for slices, not for flag name")
+
+	// a bare bool flag stays one, though true isn't a JSON array
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Bool("b", false, "")
+	var flags []bool
+	v := newJSONArrayValue(fs.Lookup("b").Value.(flag.Getter), reflect.ValueOf(&flags).Elem())
+	assert.True(t, isBoolFlag(v))
+}
+
+func TestFlagMakerNormalizeSlices(t *testing.T) {
+	type C struct {
+		Hosts  []string
+		Levels []int
+		Ports  []int
+	}
+	cases := []struct {
+		norm     SliceNormalization
+		expected string
+	}{
+		{KeepSlices, `{"Hosts":null,"Levels":[],"Ports":[80]}`},
+		{NilSlices, `{"Hosts":null,"Levels":null,"Ports":[80]}`},
+		{EmptySlices, `{"Hosts":[],"Levels":[],"Ports":[80]}`},
+	}
+	for _, c := range cases {
+		cfg := &C{Levels: []int{}, Ports: []int{80}}
+		fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, NormalizeSlices: c.norm})
+		args, err := fm.ParseArgs(cfg, []string{})
+		assert.Nil(t, err)
+		assert.Empty(t, args)
+		out, err := json.Marshal(cfg)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, string(out))
+	}
+
+	// slices emptied by the arguments are normalized too
+	cfg := &C{Hosts: []string{"a"}}
+	_, err := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, NormalizeSlices: NilSlices}).ParseArgs(cfg, []string{"--hosts="})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Hosts)
+}
+
+func TestFlagMakerOptArg(t *testing.T) {
+	type C struct {
+		Color   string `flag:"color|colour,optarg=always"`
+		Level   int    `flag:",optarg=3"`
+		Name    string
+		Verbose bool
+		Labels  map[string]string `flag:",catchall=labels."`
+	}
+	fm := NewFlagMaker()
+	c := &C{Color: "auto"}
+	args, err := fm.ParseArgs(c, []string{"--name", "--color", "--labels.x", "1", "-level", "--verbose", "--color", "rest"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rest"}, args)
+	assert.Equal(t, &C{Color: "always", Level: 3, Name: "--color", Verbose: true, Labels: map[string]string{"x": "1"}}, c)
+
+	args, err = fm.ParseArgs(c, []string{"--colour=never", "--level=1"})
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, "never", c.Color)
+	assert.Equal(t, 1, c.Level)
+
+	_, err = NewFlagMaker().ParseArgs(&struct {
+		Verbose bool `flag:",optarg=true"`
+	}{}, nil)
+	assert.EqualError(t, err, "optarg option is not supported for bools, as for flag verbose")
+}
+
+func TestArgScanner(t *testing.T) {
+	type C struct {
+		Name   string
+		Color  string `flag:"color|colour,optarg=always"`
+		Level  int
+		V      bool
+		Labels map[string]string `flag:",catchall=labels."`
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, ValueSeparator: ":"})
+	_, err := fm.ParseArgs(&C{}, nil)
+	assert.Nil(t, err)
+
+	args := []string{"--name", "--color", "--color", "-v", "--labels.x", "1", "--level:2", "--colour=never", "pos", "--name", "y"}
+	var flags []argFlag
+	s := fm.scanArgs(args)
+	for s.next() {
+		flags = append(flags, s.flag)
+	}
+	assert.Equal(t, []argFlag{
+		{index: 0, dashes: "--", name: "name", value: "--color", hasValue: true, next: true},
+		{index: 2, dashes: "--", name: "color"},
+		{index: 3, dashes: "-", name: "v"},
+		{index: 4, dashes: "--", name: "labels.x", value: "1", hasValue: true, next: true},
+		{index: 6, dashes: "--", name: "level", value: "2", hasValue: true, sep: true},
+		{index: 7, dashes: "--", name: "colour", value: "never", hasValue: true},
+	}, flags)
+	assert.Equal(t, []string{"pos", "--name", "y"}, s.rest())
+}
+
+func TestFlagMakerClockDuration(t *testing.T) {
+	type C struct {
+		Interval time.Duration `flag:",clock"`
+		Timeout  time.Duration
+	}
+	c := &C{}
+	_, err := ParseArgs(c, []string{"--interval", "01:30:00"})
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Minute, c.Interval)
+
+	_, err = ParseArgs(c, []string{"--interval", "45s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 45*time.Second, c.Interval)
+
+	for _, value := range []string{"1:30", "01:60:00", "01:3:00", "01:30:xx"} {
+		_, err = ParseArgs(c, []string{"--interval", value})
+		assert.Error(t, err, value)
+		assert.Contains(t, err.Error(), "invalid clock duration")
+		assert.Equal(t, 45*time.Second, c.Interval)
+	}
+
+	// without the option, only durations are accepted
+	_, err = ParseArgs(c, []string{"--timeout", "01:30:00"})
+	assert.Error(t, err)
+
+	_, err = ParseArgs(&struct {
+		Name string `flag:",clock"`
+	}{}, nil)
+	assert.EqualError(t, err, "clock option is only supported for durations, not for flag name")
+}
+
+func TestFlagMakerCoerce(t *testing.T) {
+	type C struct {
+		Ports []int `flag:",coerce"`
+		Sizes []int
+	}
+	c := &C{Ports: []int{80}}
+	_, err := ParseArgs(c, []string{"--ports", "3.0", "--ports", "8080", "--ports", "1e3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 8080, 1000}, c.Ports)
+
+	_, err = ParseArgs(c, []string{"--ports", "4", "--ports", "3.5"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "3.5 cannot be converted to an int without loss")
+	assert.Equal(t, []int{3, 8080, 1000}, c.Ports)
+
+	_, err = ParseArgs(c, []string{"--ports", "x"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid value")
+
+	// without the option, floats are rejected
+	_, err = ParseArgs(c, []string{"--sizes", "3.0"})
+	assert.Error(t, err)
+
+	_, err = ParseArgs(&struct {
+		Ratios []float64 `flag:",coerce"`
+	}{}, nil)
+	assert.EqualError(t, err, "coerce option is only supported for []int, not for flag ratios")
+}
+
+func TestFlagMakerMaxArgs(t *testing.T) {
+	type C struct {
+		Name    string
+		Verbose bool
+		Ports   []int             `flag:",ranges"`
+		Extra   map[string]string `flag:",catchall=extra."`
+	}
+	fm := NewFlagMakerAdv(&FlagMakingOptions{UseLowerCase: true, MaxArgs: 3})
+	c := &C{}
+	// values, and the arguments after the flags, aren't counted
+	args, err := fm.ParseArgs(c, []string{"--name", "--verbose", "--verbose", "--ports", "80,8000-8002", "a", "--b", "--c"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "--b", "--c"}, args)
+	assert.Equal(t, "--verbose", c.Name)
+	assert.Equal(t, []int{80, 8000, 8001, 8002}, c.Ports)
+
+	_, err = fm.ParseArgs(c, []string{"--name", "x", "--verbose", "--ports=1", "--name", "y"})
+	assert.EqualError(t, err, "too many flags, at most 3 are allowed")
+	assert.Equal(t, "--verbose", c.Name)
+
+	_, err = fm.ParseArgs(c, []string{"--extra.a", "1", "--extra.b=2", "--name", "x", "--name", "y"})
+	assert.EqualError(t, err, "too many flags, at most 3 are allowed")
+}
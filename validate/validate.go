@@ -0,0 +1,45 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package validate checks the objects populated by the flags package against
+// their go-playground/validator tags, e.g. `validate:"min=1"`, so that the
+// constraints already carried by the structs needn't be repeated in flag
+// tags. It's kept apart so that the flags package doesn't depend on the
+// validator.
+//
+// ValidateStruct can be registered as a cross validator, to run once the
+// overrides are applied:
+//
+//	fm.AddCrossValidator(validate.ValidateStruct)
+package validate
+
+import "github.com/go-playground/validator/v10"
+
+// validate caches the parsed tags of the types validated, and is safe for
+// concurrent use.
+var validate = validator.New()
+
+// ValidateStruct validates obj, a struct or a pointer to one, against the
+// validate tags of its fields, including the nested structs. The error
+// returned lists all the fields failing their constraints, as
+// validator.ValidationErrors.
+func ValidateStruct(obj interface{}) error {
+	return validate.Struct(obj)
+}
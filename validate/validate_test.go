@@ -0,0 +1,61 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package validate
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	flags "github.com/uber-go/flagoverride"
+)
+
+func TestValidateStruct(t *testing.T) {
+	type pool struct {
+		Size int `validate:"min=1"`
+	}
+	type C struct {
+		Name string `validate:"required"`
+		Pool pool
+	}
+	c := &C{Name: "db", Pool: pool{Size: 4}}
+	assert.Nil(t, ValidateStruct(c))
+
+	fm := flags.NewFlagMaker()
+	fm.AddCrossValidator(ValidateStruct)
+	_, err := fm.ParseArgs(c, []string{"--pool.size", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, c.Pool.Size)
+
+	_, err = fm.ParseArgs(c, []string{"--pool.size", "0", "--name", ""})
+	assert.Error(t, err)
+	var errs validator.ValidationErrors
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "C.Name", errs[0].Namespace())
+		assert.Equal(t, "C.Pool.Size", errs[1].Namespace())
+		assert.Equal(t, "min", errs[1].Tag())
+	}
+	// the overrides are rolled back
+	assert.Equal(t, &C{Name: "db", Pool: pool{Size: 2}}, c)
+
+	assert.Error(t, ValidateStruct(nil))
+}
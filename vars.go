@@ -21,11 +21,30 @@
 package flags
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"image/color"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // additional types
+type intValue int
+type int64Value int64
+type uintValue uint
+type uint64Value uint64
 type int8Value int8
 type int16Value int16
 type int32Value int32
@@ -34,7 +53,29 @@ type uint8Value uint8
 type uint32Value uint32
 type uint16Value uint16
 
+// int64 holding a duration as a number of units
+type durationUnitValue struct {
+	p    *int64
+	unit time.Duration
+}
+
 // Var handlers for each of the types
+func newIntValue(p *int) *intValue {
+	return (*intValue)(p)
+}
+
+func newInt64Value(p *int64) *int64Value {
+	return (*int64Value)(p)
+}
+
+func newUintValue(p *uint) *uintValue {
+	return (*uintValue)(p)
+}
+
+func newUint64Value(p *uint64) *uint64Value {
+	return (*uint64Value)(p)
+}
+
 func newInt8Value(p *int8) *int8Value {
 	return (*int8Value)(p)
 }
@@ -63,9 +104,50 @@ func newUint32Value(p *uint32) *uint32Value {
 	return (*uint32Value)(p)
 }
 
+func newDurationUnitValue(p *int64, unit time.Duration) *durationUnitValue {
+	return &durationUnitValue{p: p, unit: unit}
+}
+
 // Setters for each of the types
+func (f *intValue) Set(s string) error {
+	// like the flag package, accept 0x, 0o and 0b prefixes
+	v, err := parseInt(s, 0, strconv.IntSize)
+	if err != nil {
+		return err
+	}
+	*f = intValue(v)
+	return nil
+}
+
+func (f *int64Value) Set(s string) error {
+	v, err := parseInt(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	*f = int64Value(v)
+	return nil
+}
+
+func (f *uintValue) Set(s string) error {
+	v, err := parseUint(s, 0, strconv.IntSize)
+	if err != nil {
+		return err
+	}
+	*f = uintValue(v)
+	return nil
+}
+
+func (f *uint64Value) Set(s string) error {
+	v, err := parseUint(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	*f = uint64Value(v)
+	return nil
+}
+
 func (f *int8Value) Set(s string) error {
-	v, err := strconv.ParseInt(s, 10, 8)
+	v, err := parseInt(s, 10, 8)
 	if err != nil {
 		return err
 	}
@@ -74,7 +156,7 @@ func (f *int8Value) Set(s string) error {
 }
 
 func (f *int16Value) Set(s string) error {
-	v, err := strconv.ParseInt(s, 10, 16)
+	v, err := parseInt(s, 10, 16)
 	if err != nil {
 		return err
 	}
@@ -83,7 +165,7 @@ func (f *int16Value) Set(s string) error {
 }
 
 func (f *int32Value) Set(s string) error {
-	v, err := strconv.ParseInt(s, 10, 32)
+	v, err := parseInt(s, 10, 32)
 	if err != nil {
 		return err
 	}
@@ -101,7 +183,7 @@ func (f *f32Value) Set(s string) error {
 }
 
 func (f *uint8Value) Set(s string) error {
-	v, err := strconv.ParseUint(s, 10, 8)
+	v, err := parseUint(s, 10, 8)
 	if err != nil {
 		return err
 	}
@@ -110,7 +192,7 @@ func (f *uint8Value) Set(s string) error {
 }
 
 func (f *uint16Value) Set(s string) error {
-	v, err := strconv.ParseUint(s, 10, 16)
+	v, err := parseUint(s, 10, 16)
 	if err != nil {
 		return err
 	}
@@ -119,7 +201,7 @@ func (f *uint16Value) Set(s string) error {
 }
 
 func (f *uint32Value) Set(s string) error {
-	v, err := strconv.ParseUint(s, 10, 32)
+	v, err := parseUint(s, 10, 32)
 	if err != nil {
 		return err
 	}
@@ -127,7 +209,52 @@ func (f *uint32Value) Set(s string) error {
 	return nil
 }
 
+// parseInt parses s as a signed integer in the given base and of the given
+// size, reporting an overflow explicitly rather than as a strconv range error.
+func parseInt(s string, base, bitSize int) (int64, error) {
+	v, err := strconv.ParseInt(s, base, bitSize)
+	if isRangeErr(err) {
+		return 0, fmt.Errorf("value %s overflows int%d", s, bitSize)
+	}
+	return v, err
+}
+
+// parseUint parses s as an unsigned integer in the given base and of the
+// given size, reporting an overflow explicitly rather than as a strconv range
+// error.
+func parseUint(s string, base, bitSize int) (uint64, error) {
+	v, err := strconv.ParseUint(s, base, bitSize)
+	if isRangeErr(err) {
+		return 0, fmt.Errorf("value %s overflows uint%d", s, bitSize)
+	}
+	return v, err
+}
+
+// isRangeErr tells whether err is a strconv error for a value out of the
+// range of the parsed type.
+func isRangeErr(err error) bool {
+	ne, ok := err.(*strconv.NumError)
+	return ok && ne.Err == strconv.ErrRange
+}
+
+func (f *durationUnitValue) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v%f.unit != 0 {
+		// truncating would silently lose precision
+		return fmt.Errorf("duration %s is not a whole number of %s", s, f.unit)
+	}
+	*f.p = int64(v / f.unit)
+	return nil
+}
+
 // Getters for each of the types
+func (f *intValue) Get() interface{}    { return int(*f) }
+func (f *int64Value) Get() interface{}  { return int64(*f) }
+func (f *uintValue) Get() interface{}   { return uint(*f) }
+func (f *uint64Value) Get() interface{} { return uint64(*f) }
 func (f *int8Value) Get() interface{}   { return int8(*f) }
 func (f *int16Value) Get() interface{}  { return int16(*f) }
 func (f *int32Value) Get() interface{}  { return int32(*f) }
@@ -136,7 +263,13 @@ func (f *uint8Value) Get() interface{}  { return uint8(*f) }
 func (f *uint16Value) Get() interface{} { return uint16(*f) }
 func (f *uint32Value) Get() interface{} { return uint32(*f) }
 
+func (f *durationUnitValue) Get() interface{} { return *f.p }
+
 // Stringers for each of the types
+func (f *intValue) String() string    { return fmt.Sprintf("%v", *f) }
+func (f *int64Value) String() string  { return fmt.Sprintf("%v", *f) }
+func (f *uintValue) String() string   { return fmt.Sprintf("%v", *f) }
+func (f *uint64Value) String() string { return fmt.Sprintf("%v", *f) }
 func (f *int8Value) String() string   { return fmt.Sprintf("%v", *f) }
 func (f *int16Value) String() string  { return fmt.Sprintf("%v", *f) }
 func (f *int32Value) String() string  { return fmt.Sprintf("%v", *f) }
@@ -145,6 +278,409 @@ func (f *uint8Value) String() string  { return fmt.Sprintf("%v", *f) }
 func (f *uint16Value) String() string { return fmt.Sprintf("%v", *f) }
 func (f *uint32Value) String() string { return fmt.Sprintf("%v", *f) }
 
+func (f *durationUnitValue) String() string {
+	d := time.Duration(*f.p) * f.unit
+	if d/f.unit != time.Duration(*f.p) {
+		// beyond the range of time.Duration
+		return fmt.Sprintf("%d*%v", *f.p, f.unit)
+	}
+	return d.String()
+}
+
+// countValue counts the occurrences of a flag in an integer field. Like a bool
+// flag, it takes no value unless given with =.
+type countValue struct {
+	v   reflect.Value
+	set bool // the count restarts from zero in each parse
+}
+
+func newCountValue(v reflect.Value) *countValue {
+	return &countValue{v: v}
+}
+
+func (c *countValue) Set(s string) error {
+	if !c.set {
+		c.v.SetInt(0)
+		c.set = true
+	}
+	// a number sets the count, e.g. -v=1, true increments it, as a bare -v
+	// does, and false resets it
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid count %q", s)
+		}
+		n = 0
+		if b {
+			n = c.v.Int() + 1
+		}
+	}
+	if c.v.OverflowInt(n) {
+		return fmt.Errorf("count %d overflows %s", n, c.v.Type())
+	}
+	c.v.SetInt(n)
+	return nil
+}
+
+func (c *countValue) Get() interface{} { return c.v.Interface() }
+
+func (c *countValue) String() string {
+	if !c.v.IsValid() {
+		return "0"
+	}
+	return fmt.Sprint(c.v.Interface())
+}
+
+func (c *countValue) IsBoolFlag() bool { return true }
+
+func (c *countValue) reset() {
+	c.set = false
+}
+
+// MAC address
+type hardwareAddrValue struct {
+	p *net.HardwareAddr
+}
+
+func newHardwareAddrValue(p *net.HardwareAddr) *hardwareAddrValue {
+	return &hardwareAddrValue{p: p}
+}
+
+func (h *hardwareAddrValue) Set(s string) error {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return fmt.Errorf("invalid MAC value: %v", err)
+	}
+	*h.p = mac
+	return nil
+}
+
+func (h *hardwareAddrValue) Get() interface{} {
+	return *h.p
+}
+
+func (h *hardwareAddrValue) String() string {
+	return h.p.String()
+}
+
+// exact fraction
+type ratValue struct {
+	p **big.Rat
+}
+
+func newRatValue(p **big.Rat) *ratValue {
+	return &ratValue{p: p}
+}
+
+func (r *ratValue) Set(s string) error {
+	v, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return fmt.Errorf("invalid fraction %q", s)
+	}
+	if *r.p == nil {
+		*r.p = v
+	} else {
+		(*r.p).Set(v)
+	}
+	return nil
+}
+
+func (r *ratValue) Get() interface{} {
+	return *r.p
+}
+
+func (r *ratValue) String() string {
+	if *r.p == nil {
+		return ""
+	}
+	return (*r.p).RatString()
+}
+
+// time zone
+type locationValue struct {
+	p **time.Location
+}
+
+func newLocationValue(p **time.Location) *locationValue {
+	return &locationValue{p: p}
+}
+
+func (l *locationValue) Set(s string) error {
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return err
+	}
+	*l.p = loc
+	return nil
+}
+
+func (l *locationValue) Get() interface{} {
+	return *l.p
+}
+
+func (l *locationValue) String() string {
+	if *l.p == nil {
+		return ""
+	}
+	return (*l.p).String()
+}
+
+// value decoded by a codec registered with RegisterCodec
+type codecValue struct {
+	v      reflect.Value
+	decode func(string, reflect.Value) error
+}
+
+func newCodecValue(v reflect.Value, decode func(string, reflect.Value) error) *codecValue {
+	return &codecValue{v: v, decode: decode}
+}
+
+func (c *codecValue) Set(s string) error {
+	return c.decode(s, c.v)
+}
+
+func (c *codecValue) Get() interface{} {
+	return c.v.Interface()
+}
+
+func (c *codecValue) String() string {
+	if !c.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(c.v.Interface())
+}
+
+// hex color
+type rgbaValue struct {
+	p *color.RGBA
+}
+
+func newRGBAValue(p *color.RGBA) *rgbaValue {
+	return &rgbaValue{p: p}
+}
+
+// Set parses #RRGGBB, which is opaque, or #RRGGBBAA.
+func (c *rgbaValue) Set(s string) error {
+	if !strings.HasPrefix(s, "#") || (len(s) != 7 && len(s) != 9) {
+		return fmt.Errorf("invalid color %q, expected #RRGGBB or #RRGGBBAA", s)
+	}
+	b, err := hex.DecodeString(s[1:])
+	if err != nil {
+		return fmt.Errorf("invalid color %q: %v", s, err)
+	}
+	rgba := color.RGBA{R: b[0], G: b[1], B: b[2], A: 0xff}
+	if len(b) == 4 {
+		rgba.A = b[3]
+	}
+	*c.p = rgba
+	return nil
+}
+
+func (c *rgbaValue) Get() interface{} {
+	return *c.p
+}
+
+func (c *rgbaValue) String() string {
+	if c.p == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.p.R, c.p.G, c.p.B, c.p.A)
+}
+
+// duration given as a clock time
+type clockDurationValue struct {
+	p *time.Duration
+}
+
+func newClockDurationValue(p *time.Duration) *clockDurationValue {
+	return &clockDurationValue{p: p}
+}
+
+// Set parses HH:MM:SS, e.g. 01:30:00 for 1h30m, or a Go duration.
+func (c *clockDurationValue) Set(s string) error {
+	v, err := parseClockDuration(s)
+	if err != nil {
+		return err
+	}
+	*c.p = v
+	return nil
+}
+
+func parseClockDuration(s string) (time.Duration, error) {
+	if !strings.Contains(s, ":") {
+		return time.ParseDuration(s)
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid clock duration %q, expected HH:MM:SS", s)
+	}
+	var fields [3]int64
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 31)
+		if err != nil || (i > 0 && (len(part) != 2 || n > 59)) {
+			return 0, fmt.Errorf("invalid clock duration %q, expected HH:MM:SS", s)
+		}
+		fields[i] = int64(n)
+	}
+	return time.Duration(fields[0])*time.Hour + time.Duration(fields[1])*time.Minute + time.Duration(fields[2])*time.Second, nil
+}
+
+func (c *clockDurationValue) Get() interface{} {
+	return *c.p
+}
+
+func (c *clockDurationValue) String() string {
+	if c.p == nil {
+		return ""
+	}
+	return c.p.String()
+}
+
+// regular expression
+type regexpValue struct {
+	p       **regexp.Regexp
+	timeout time.Duration
+}
+
+func newRegexpValue(p **regexp.Regexp, timeout time.Duration) *regexpValue {
+	return &regexpValue{p: p, timeout: timeout}
+}
+
+// Set compiles the expression, giving up after the timeout if any. The
+// compilation keeps running in the background until it completes though.
+func (r *regexpValue) Set(s string) error {
+	if r.timeout <= 0 {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		*r.p = re
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	type result struct {
+		re  *regexp.Regexp
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		re, err := regexp.Compile(s)
+		done <- result{re, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return res.err
+		}
+		*r.p = res.re
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("compiling %q timed out after %v", s, r.timeout)
+	}
+}
+
+func (r *regexpValue) Get() interface{} {
+	return *r.p
+}
+
+func (r *regexpValue) String() string {
+	if *r.p == nil {
+		return ""
+	}
+	return (*r.p).String()
+}
+
+// JSON number, kept verbatim
+type jsonNumberValue struct {
+	p *json.Number
+}
+
+// jsonNumberPattern matches the numbers of the JSON grammar.
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+func newJSONNumberValue(p *json.Number) *jsonNumberValue {
+	return &jsonNumberValue{p: p}
+}
+
+func (n *jsonNumberValue) Set(s string) error {
+	if !jsonNumberPattern.MatchString(s) {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*n.p = json.Number(s)
+	return nil
+}
+
+func (n *jsonNumberValue) Get() interface{} {
+	return *n.p
+}
+
+func (n *jsonNumberValue) String() string {
+	return string(*n.p)
+}
+
+// integer enum taking the names of its values
+type enumValue struct {
+	field  reflect.Value
+	values map[string]int32
+}
+
+func newEnumValue(field reflect.Value, values map[string]int32) *enumValue {
+	return &enumValue{field: field, values: values}
+}
+
+func (e *enumValue) Set(s string) error {
+	v, ok := e.values[s]
+	if !ok {
+		names := make([]string, 0, len(e.values))
+		for name := range e.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown value %q, valid values are %s", s, strings.Join(names, ", "))
+	}
+	e.field.SetInt(int64(v))
+	return nil
+}
+
+func (e *enumValue) Get() interface{} {
+	return e.field.Interface()
+}
+
+func (e *enumValue) String() string {
+	if !e.field.IsValid() {
+		return ""
+	}
+	found := ""
+	for name, v := range e.values {
+		// pick the first name of aliased values
+		if int64(v) == e.field.Int() && (len(found) == 0 || name < found) {
+			found = name
+		}
+	}
+	if len(found) == 0 {
+		return strconv.FormatInt(e.field.Int(), 10)
+	}
+	return found
+}
+
+// multiValue is implemented by the flag values which accumulate several
+// values, one per occurrence of the flag.
+type multiValue interface {
+	flag.Value
+	// values returns the elements in a form accepted by Set.
+	values() []string
+}
+
+// resetter is implemented by the flag values which keep state during a parse.
+// reset is called before each parse, so that e.g. the first value of a
+// multi-value flag clears the slice again.
+type resetter interface {
+	reset()
+}
+
 // string slice
 
 type strSlice struct {
@@ -176,6 +712,14 @@ func (s *strSlice) String() string {
 	return fmt.Sprintf("%v", *s.s)
 }
 
+func (s *strSlice) values() []string {
+	return append([]string(nil), *s.s...)
+}
+
+func (s *strSlice) reset() {
+	s.set = false
+}
+
 // int slice
 type intSlice struct {
 	s   *[]int
@@ -191,6 +735,9 @@ func newIntSlice(p *[]int) *intSlice {
 
 func (is *intSlice) Set(str string) error {
 	i, err := strconv.Atoi(str)
+	if isRangeErr(err) {
+		_, err = parseInt(str, 10, strconv.IntSize)
+	}
 	if err != nil {
 		return err
 	}
@@ -210,6 +757,64 @@ func (is *intSlice) String() string {
 	return fmt.Sprintf("%v", *is.s)
 }
 
+func (is *intSlice) values() []string {
+	vals := make([]string, len(*is.s))
+	for i, v := range *is.s {
+		vals[i] = strconv.Itoa(v)
+	}
+	return vals
+}
+
+func (is *intSlice) reset() {
+	is.set = false
+}
+
+// bool slice
+type boolSlice struct {
+	s   *[]bool
+	set bool
+}
+
+func newBoolSlice(p *[]bool) *boolSlice {
+	return &boolSlice{
+		s:   p,
+		set: false,
+	}
+}
+
+func (bs *boolSlice) Set(str string) error {
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return err
+	}
+	if !bs.set {
+		*bs.s = (*bs.s)[:0]
+		bs.set = true
+	}
+	*bs.s = append(*bs.s, b)
+	return nil
+}
+
+func (bs *boolSlice) Get() interface{} {
+	return []bool(*bs.s)
+}
+
+func (bs *boolSlice) String() string {
+	return fmt.Sprintf("%v", *bs.s)
+}
+
+func (bs *boolSlice) values() []string {
+	vals := make([]string, len(*bs.s))
+	for i, v := range *bs.s {
+		vals[i] = strconv.FormatBool(v)
+	}
+	return vals
+}
+
+func (bs *boolSlice) reset() {
+	bs.set = false
+}
+
 // float64 slice
 type float64Slice struct {
 	s   *[]float64
@@ -243,3 +848,1101 @@ func (is *float64Slice) Get() interface{} {
 func (is *float64Slice) String() string {
 	return fmt.Sprintf("%v", *is.s)
 }
+
+func (is *float64Slice) values() []string {
+	vals := make([]string, len(*is.s))
+	for i, v := range *is.s {
+		vals[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return vals
+}
+
+func (is *float64Slice) reset() {
+	is.set = false
+}
+
+// rune slice
+type runeSlice struct {
+	s   *[]rune
+	set bool
+}
+
+func newRuneSlice(p *[]rune) *runeSlice {
+	return &runeSlice{
+		s:   p,
+		set: false,
+	}
+}
+
+func (rs *runeSlice) Set(str string) error {
+	if !rs.set {
+		*rs.s = (*rs.s)[:0]
+		rs.set = true
+	}
+	*rs.s = append(*rs.s, []rune(str)...)
+	return nil
+}
+
+func (rs *runeSlice) Get() interface{} {
+	return []rune(*rs.s)
+}
+
+func (rs *runeSlice) String() string {
+	return fmt.Sprintf("%q", string(*rs.s))
+}
+
+func (rs *runeSlice) values() []string {
+	vals := make([]string, len(*rs.s))
+	for i, r := range *rs.s {
+		vals[i] = string(r)
+	}
+	return vals
+}
+
+func (rs *runeSlice) reset() {
+	rs.set = false
+}
+
+// time.Time slice
+type timeSlice struct {
+	s      *[]time.Time
+	layout string
+	set    bool
+}
+
+func newTimeSlice(p *[]time.Time, layout string) *timeSlice {
+	return &timeSlice{
+		s:      p,
+		layout: layout,
+		set:    false,
+	}
+}
+
+func (ts *timeSlice) Set(str string) error {
+	t, err := time.Parse(ts.layout, str)
+	if err != nil {
+		return err
+	}
+	if !ts.set {
+		*ts.s = (*ts.s)[:0]
+		ts.set = true
+	}
+	*ts.s = append(*ts.s, t)
+	return nil
+}
+
+func (ts *timeSlice) Get() interface{} {
+	return []time.Time(*ts.s)
+}
+
+func (ts *timeSlice) String() string {
+	return fmt.Sprintf("%v", *ts.s)
+}
+
+func (ts *timeSlice) values() []string {
+	vals := make([]string, len(*ts.s))
+	for i, v := range *ts.s {
+		vals[i] = v.Format(ts.layout)
+	}
+	return vals
+}
+
+func (ts *timeSlice) reset() {
+	ts.set = false
+}
+
+// net.IP slice
+type ipSlice struct {
+	s   *[]net.IP
+	set bool
+}
+
+func newIPSlice(p *[]net.IP) *ipSlice {
+	return &ipSlice{
+		s:   p,
+		set: false,
+	}
+}
+
+func (is *ipSlice) Set(str string) error {
+	ip := net.ParseIP(str)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q", str)
+	}
+	if !is.set {
+		*is.s = (*is.s)[:0]
+		is.set = true
+	}
+	*is.s = append(*is.s, ip)
+	return nil
+}
+
+func (is *ipSlice) Get() interface{} {
+	return []net.IP(*is.s)
+}
+
+func (is *ipSlice) String() string {
+	return fmt.Sprintf("%v", *is.s)
+}
+
+func (is *ipSlice) values() []string {
+	vals := make([]string, len(*is.s))
+	for i, ip := range *is.s {
+		vals[i] = ip.String()
+	}
+	return vals
+}
+
+func (is *ipSlice) reset() {
+	is.set = false
+}
+
+// slice of string maps, each value giving the pairs of a map, e.g. k1=v1,k2=v2
+type mapSlice struct {
+	s   *[]map[string]string
+	set bool
+}
+
+func newMapSlice(p *[]map[string]string) *mapSlice {
+	return &mapSlice{
+		s:   p,
+		set: false,
+	}
+}
+
+func (ms *mapSlice) Set(str string) error {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(str, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || len(key) == 0 {
+			return fmt.Errorf("invalid pair %q, expected key=value", pair)
+		}
+		m[key] = val
+	}
+	if !ms.set {
+		*ms.s = (*ms.s)[:0]
+		ms.set = true
+	}
+	*ms.s = append(*ms.s, m)
+	return nil
+}
+
+func (ms *mapSlice) Get() interface{} {
+	return []map[string]string(*ms.s)
+}
+
+func (ms *mapSlice) String() string {
+	return fmt.Sprintf("%v", *ms.s)
+}
+
+func (ms *mapSlice) values() []string {
+	vals := make([]string, len(*ms.s))
+	for i, m := range *ms.s {
+		pairs := make([]string, 0, len(m))
+		for k, v := range m {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		vals[i] = strings.Join(pairs, ",")
+	}
+	return vals
+}
+
+func (ms *mapSlice) reset() {
+	ms.set = false
+}
+
+// sortSlice sorts the elements of the slice in their natural order.
+func sortSlice(v reflect.Value) {
+	sort.SliceStable(v.Interface(), func(i, j int) bool {
+		return lessValue(v.Index(i), v.Index(j))
+	})
+}
+
+func lessValue(a, b reflect.Value) bool {
+	if a.Type() == timeType {
+		return a.Interface().(time.Time).Before(b.Interface().(time.Time))
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	}
+	return false
+}
+
+// dedupSlice removes the duplicated elements of the slice, keeping the first
+// occurrence of each element.
+func dedupSlice(v reflect.Value) {
+	seen := make(map[interface{}]bool, v.Len())
+	n := 0
+	for i := 0; i < v.Len(); i++ {
+		key := v.Index(i).Interface()
+		if !v.Index(i).Type().Comparable() {
+			// e.g. net.IP
+			key = fmt.Sprint(key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		v.Index(n).Set(v.Index(i))
+		n++
+	}
+	v.SetLen(n)
+}
+
+// bool map
+type boolMap struct {
+	m       *map[string]bool
+	set     bool
+	dupKeys string
+}
+
+func newBoolMap(p *map[string]bool) *boolMap {
+	return &boolMap{
+		m:   p,
+		set: false,
+	}
+}
+
+// The values of the dupkeys option of map flags: a key given twice in a parse
+// keeps its last value, its first value, or is an error.
+const (
+	dupKeysLast  = "last"
+	dupKeysFirst = "first"
+	dupKeysError = "error"
+)
+
+// Set accepts key=value, or a bare key meaning key=true.
+func (bm *boolMap) Set(str string) error {
+	key, val := str, true
+	if i := strings.Index(str, "="); i >= 0 {
+		b, err := strconv.ParseBool(str[i+1:])
+		if err != nil {
+			return err
+		}
+		key, val = str[:i], b
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("missing key in %q", str)
+	}
+	if !bm.set || *bm.m == nil {
+		*bm.m = make(map[string]bool)
+		bm.set = true
+	}
+	if _, ok := (*bm.m)[key]; ok {
+		switch bm.dupKeys {
+		case dupKeysFirst:
+			return nil
+		case dupKeysError:
+			return fmt.Errorf("duplicate key %q", key)
+		}
+	}
+	(*bm.m)[key] = val
+	return nil
+}
+
+func (bm *boolMap) Get() interface{} {
+	return map[string]bool(*bm.m)
+}
+
+func (bm *boolMap) String() string {
+	return fmt.Sprintf("%v", *bm.m)
+}
+
+func (bm *boolMap) values() []string {
+	vals := make([]string, 0, len(*bm.m))
+	for k, v := range *bm.m {
+		vals = append(vals, k+"="+strconv.FormatBool(v))
+	}
+	sort.Strings(vals)
+	return vals
+}
+
+func (bm *boolMap) reset() {
+	bm.set = false
+}
+
+// string map
+type stringMap struct {
+	m       *map[string]string
+	set     bool
+	dupKeys string
+}
+
+func newStringMap(p *map[string]string) *stringMap {
+	return &stringMap{
+		m:   p,
+		set: false,
+	}
+}
+
+// Set accepts key=value, the value being everything after the first =, e.g.
+// k=a=b gives the key k the value a=b.
+func (sm *stringMap) Set(str string) error {
+	key, val, ok := strings.Cut(str, "=")
+	if !ok {
+		return fmt.Errorf("invalid pair %q, expected key=value", str)
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("missing key in %q", str)
+	}
+	if !sm.set || *sm.m == nil {
+		*sm.m = make(map[string]string)
+		sm.set = true
+	}
+	if _, ok := (*sm.m)[key]; ok {
+		switch sm.dupKeys {
+		case dupKeysFirst:
+			return nil
+		case dupKeysError:
+			return fmt.Errorf("duplicate key %q", key)
+		}
+	}
+	(*sm.m)[key] = val
+	return nil
+}
+
+func (sm *stringMap) Get() interface{} {
+	return map[string]string(*sm.m)
+}
+
+func (sm *stringMap) String() string {
+	return fmt.Sprintf("%v", *sm.m)
+}
+
+func (sm *stringMap) values() []string {
+	vals := make([]string, 0, len(*sm.m))
+	for k, v := range *sm.m {
+		vals = append(vals, k+"="+v)
+	}
+	sort.Strings(vals)
+	return vals
+}
+
+func (sm *stringMap) reset() {
+	sm.set = false
+}
+
+// checkedValue passes the raw values through checks and transforms before
+// handing them to the wrapped flag value. If a value is rejected, by a check
+// or by the wrapped value, after some values of a multi-value flag were
+// accepted, the field is restored to its value before the parse, so that the
+// override is discarded as a whole.
+type checkedValue struct {
+	flag.Getter
+	field reflect.Value
+	saved reflect.Value
+	steps []func(string) (string, error)
+}
+
+func newCheckedValue(v flag.Getter, field reflect.Value, steps []func(string) (string, error)) *checkedValue {
+	return &checkedValue{
+		Getter: v,
+		field:  field,
+		steps:  steps,
+	}
+}
+
+func (c *checkedValue) Set(str string) error {
+	if !c.saved.IsValid() {
+		c.saved = copyValue(c.field)
+	}
+	for _, step := range c.steps {
+		var err error
+		if str, err = step(str); err != nil {
+			c.field.Set(c.saved)
+			return err
+		}
+	}
+	if err := c.Getter.Set(str); err != nil {
+		// the wrapped value rejected it, e.g. an invalid number
+		c.field.Set(c.saved)
+		return err
+	}
+	return nil
+}
+
+func (c *checkedValue) IsBoolFlag() bool { return isBoolFlag(c.Getter) }
+
+func (c *checkedValue) unwrap() flag.Value { return c.Getter }
+
+func (c *checkedValue) reset() {
+	c.saved = reflect.Value{}
+	if r, ok := c.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// splitValue splits each raw value into several values handed one by one to
+// the wrapped multi-value flag.
+type splitValue struct {
+	flag.Getter
+	split func(string) ([]string, error)
+}
+
+func newSplitValue(v flag.Getter, split func(string) ([]string, error)) *splitValue {
+	return &splitValue{
+		Getter: v,
+		split:  split,
+	}
+}
+
+func (s *splitValue) Set(str string) error {
+	vals, err := s.split(str)
+	if err != nil {
+		return err
+	}
+	for _, v := range vals {
+		if err := s.Getter.Set(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *splitValue) IsBoolFlag() bool { return isBoolFlag(s.Getter) }
+
+func (s *splitValue) unwrap() flag.Value { return s.Getter }
+
+func (s *splitValue) reset() {
+	if r, ok := s.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// jsonArrayValue hands the elements of each raw value, a JSON array, one by
+// one to the wrapped multi-value flag, unquoting the strings.
+type jsonArrayValue struct {
+	flag.Getter
+	field reflect.Value
+}
+
+func newJSONArrayValue(v flag.Getter, field reflect.Value) *jsonArrayValue {
+	return &jsonArrayValue{Getter: v, field: field}
+}
+
+func (j *jsonArrayValue) Set(str string) error {
+	var elems []json.RawMessage
+	if err := json.Unmarshal([]byte(str), &elems); err != nil {
+		return fmt.Errorf("invalid JSON array: %v", err)
+	}
+	if len(elems) == 0 {
+		j.field.Set(reflect.MakeSlice(j.field.Type(), 0, 0))
+		return nil
+	}
+	for _, elem := range elems {
+		var val string
+		if err := json.Unmarshal(elem, &val); err != nil {
+			// numbers and booleans are given as is
+			val = string(elem)
+		}
+		if err := j.Getter.Set(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *jsonArrayValue) IsBoolFlag() bool { return isBoolFlag(j.Getter) }
+
+func (j *jsonArrayValue) unwrap() flag.Value { return j.Getter }
+
+func (j *jsonArrayValue) reset() {
+	if r, ok := j.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// appendValue keeps the elements a slice has before a parse in front of the
+// ones given by the parse, in order, instead of replacing them.
+type appendValue struct {
+	flag.Getter
+	field   reflect.Value
+	started bool
+}
+
+func newAppendValue(v flag.Getter, field reflect.Value) *appendValue {
+	return &appendValue{
+		Getter: v,
+		field:  field,
+	}
+}
+
+func (a *appendValue) Set(str string) error {
+	if a.started {
+		return a.Getter.Set(str)
+	}
+	saved := copyValue(a.field)
+	if err := a.Getter.Set(str); err != nil {
+		return err
+	}
+	a.field.Set(reflect.AppendSlice(saved, a.field))
+	a.started = true
+	return nil
+}
+
+func (a *appendValue) IsBoolFlag() bool { return isBoolFlag(a.Getter) }
+
+func (a *appendValue) unwrap() flag.Value { return a.Getter }
+
+func (a *appendValue) reset() {
+	a.started = false
+	if r, ok := a.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// growValue grows a slice up to the element whose field it sets.
+type growValue struct {
+	flag.Getter
+	slice reflect.Value
+	index int
+}
+
+func newGrowValue(v flag.Getter, slice reflect.Value, index int) *growValue {
+	return &growValue{
+		Getter: v,
+		slice:  slice,
+		index:  index,
+	}
+}
+
+func (g *growValue) Set(str string) error {
+	if err := g.Getter.Set(str); err != nil {
+		return err
+	}
+	if g.slice.Len() <= g.index {
+		g.slice.SetLen(g.index + 1)
+	}
+	return nil
+}
+
+func (g *growValue) IsBoolFlag() bool { return isBoolFlag(g.Getter) }
+
+func (g *growValue) unwrap() flag.Value { return g.Getter }
+
+func (g *growValue) reset() {
+	if r, ok := g.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// aliasValue sets the value of the flag it's an alias of, with a warning.
+type aliasValue struct {
+	flag.Getter
+	warn func()
+}
+
+func newAliasValue(v flag.Getter, warn func()) *aliasValue {
+	return &aliasValue{
+		Getter: v,
+		warn:   warn,
+	}
+}
+
+func (a *aliasValue) Set(str string) error {
+	a.warn()
+	return a.Getter.Set(str)
+}
+
+func (a *aliasValue) IsBoolFlag() bool { return isBoolFlag(a.Getter) }
+
+func (a *aliasValue) unwrap() flag.Value { return a.Getter }
+
+// profileValue is the value of the flag selecting a profile, which is applied
+// before the parse. Setting it only checks the profile exists.
+type profileValue struct {
+	profiles map[string]interface{}
+	name     string
+}
+
+func newProfileValue(profiles map[string]interface{}) *profileValue {
+	return &profileValue{profiles: profiles}
+}
+
+func (p *profileValue) Set(str string) error {
+	if _, ok := p.profiles[str]; !ok {
+		return fmt.Errorf("unknown profile %q", str)
+	}
+	p.name = str
+	return nil
+}
+
+func (p *profileValue) Get() interface{} { return p.name }
+
+func (p *profileValue) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.name
+}
+
+// collectValue records the errors of the wrapped multi-value flag rather than
+// returning them, so that all the invalid values are reported at once.
+type collectValue struct {
+	flag.Getter
+	errs []string
+}
+
+func newCollectValue(v flag.Getter) *collectValue {
+	return &collectValue{Getter: v}
+}
+
+func (c *collectValue) Set(str string) error {
+	if err := c.Getter.Set(str); err != nil {
+		c.errs = append(c.errs, fmt.Sprintf("%q: %v", str, err))
+	}
+	return nil
+}
+
+func (c *collectValue) IsBoolFlag() bool { return isBoolFlag(c.Getter) }
+
+func (c *collectValue) unwrap() flag.Value { return c.Getter }
+
+func (c *collectValue) reset() {
+	c.errs = nil
+	if r, ok := c.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// clearValue empties the field of the wrapped multi-value flag when it's
+// given an empty value, e.g. --hosts=, rather than adding an empty element.
+// The values given after it are added to the emptied field, including the
+// empty ones.
+type clearValue struct {
+	flag.Getter
+	field   reflect.Value
+	cleared bool // the field is cleared once per parse
+}
+
+func newClearValue(v flag.Getter, field reflect.Value) *clearValue {
+	return &clearValue{Getter: v, field: field}
+}
+
+func (c *clearValue) Set(str string) error {
+	if len(str) > 0 || c.cleared {
+		return c.Getter.Set(str)
+	}
+	c.cleared = true
+	if c.field.Kind() == reflect.Map {
+		c.field.Set(reflect.MakeMap(c.field.Type()))
+	} else {
+		c.field.Set(reflect.MakeSlice(c.field.Type(), 0, 0))
+	}
+	return nil
+}
+
+func (c *clearValue) String() string {
+	if c.Getter == nil {
+		// the zero value PrintDefaults compares the default with
+		return ""
+	}
+	return c.Getter.String()
+}
+
+func (c *clearValue) IsBoolFlag() bool { return isBoolFlag(c.Getter) }
+
+func (c *clearValue) unwrap() flag.Value { return c.Getter }
+
+func (c *clearValue) reset() {
+	c.cleared = false
+	if r, ok := c.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// unsetValue resets the field of the wrapped flag to its zero value when it's
+// given the token of the UnsetToken option.
+type unsetValue struct {
+	flag.Getter
+	field reflect.Value
+	token string
+}
+
+func newUnsetValue(v flag.Getter, field reflect.Value, token string) *unsetValue {
+	return &unsetValue{Getter: v, field: field, token: token}
+}
+
+func (u *unsetValue) Set(str string) error {
+	if str != u.token {
+		return u.Getter.Set(str)
+	}
+	u.field.Set(reflect.Zero(u.field.Type()))
+	return nil
+}
+
+func (u *unsetValue) String() string {
+	if u.Getter == nil {
+		// the zero value PrintDefaults compares the default with
+		return ""
+	}
+	return u.Getter.String()
+}
+
+func (u *unsetValue) IsBoolFlag() bool { return isBoolFlag(u.Getter) }
+
+func (u *unsetValue) unwrap() flag.Value { return u.Getter }
+
+func (u *unsetValue) reset() {
+	if r, ok := u.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// formatValue passes the errors of the values the wrapped flag value rejects
+// through the ErrorFormatter option.
+type formatValue struct {
+	flag.Getter
+	format func(str string, err error) error
+}
+
+func newFormatValue(v flag.Getter, format func(str string, err error) error) *formatValue {
+	return &formatValue{Getter: v, format: format}
+}
+
+func (f *formatValue) Set(str string) error {
+	if err := f.Getter.Set(str); err != nil {
+		return f.format(str, err)
+	}
+	return nil
+}
+
+func (f *formatValue) IsBoolFlag() bool { return isBoolFlag(f.Getter) }
+
+func (f *formatValue) unwrap() flag.Value { return f.Getter }
+
+func (f *formatValue) reset() {
+	if r, ok := f.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// splitLines returns the trimmed non-blank lines of str.
+func splitLines(str string) ([]string, error) {
+	var lines []string
+	for _, line := range strings.Split(str, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// maxRangeLen bounds the number of elements a range given to a flag with the
+// ranges option expands to.
+const maxRangeLen = 1 << 16
+
+// splitRanges splits the comma separated integers and ranges of str, e.g.
+// 80,8000-8002, expanding the ranges, e.g. into 80, 8000, 8001 and 8002.
+func splitRanges(str string) ([]string, error) {
+	var vals []string
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		// a leading - is the sign of the lower bound
+		i := strings.Index(strings.TrimPrefix(part, "-"), "-")
+		if i < 0 {
+			vals = append(vals, part)
+			continue
+		}
+		if strings.HasPrefix(part, "-") {
+			i++
+		}
+		lo, errLo := strconv.Atoi(part[:i])
+		hi, errHi := strconv.Atoi(part[i+1:])
+		switch {
+		case errLo != nil || errHi != nil:
+			return nil, fmt.Errorf("invalid range %q", part)
+		case lo > hi:
+			return nil, fmt.Errorf("reversed range %q", part)
+		case hi-lo >= maxRangeLen:
+			return nil, fmt.Errorf("range %q has more than %d values", part, maxRangeLen)
+		}
+		for n := lo; n <= hi; n++ {
+			vals = append(vals, strconv.Itoa(n))
+		}
+	}
+	return vals, nil
+}
+
+// lazyValue attaches the value it's defined on to a nil pointer field when
+// it's set.
+type lazyValue struct {
+	flag.Getter
+	field  reflect.Value
+	target reflect.Value
+}
+
+func newLazyValue(v flag.Getter, field, target reflect.Value) *lazyValue {
+	return &lazyValue{
+		Getter: v,
+		field:  field,
+		target: target,
+	}
+}
+
+func (l *lazyValue) Set(str string) error {
+	if u := unsetOf(l.Getter); u != nil && str == u.token {
+		// the pointer is unset rather than pointing to a zero value, and
+		// what it pointed to is left alone
+		l.detach()
+		return nil
+	}
+	if err := l.Getter.Set(str); err != nil {
+		return err
+	}
+	l.attach()
+	return nil
+}
+
+func (l *lazyValue) IsBoolFlag() bool { return isBoolFlag(l.Getter) }
+
+func (l *lazyValue) unwrap() flag.Value { return l.Getter }
+
+func (l *lazyValue) reset() {
+	if r, ok := l.Getter.(resetter); ok {
+		r.reset()
+	}
+}
+
+// isNil tells whether the pointer field is still nil.
+func (l *lazyValue) isNil() bool { return l.field.IsNil() }
+
+// attach points the pointer field to the detached value if it's still nil.
+func (l *lazyValue) attach() {
+	if l.field.IsNil() {
+		l.field.Set(l.target)
+	}
+}
+
+// detach sets the pointer field back to nil.
+func (l *lazyValue) detach() { l.field.Set(reflect.Zero(l.field.Type())) }
+
+// unsetOf returns the unsetValue among the wrappers of v, if any.
+func unsetOf(v flag.Value) *unsetValue {
+	for {
+		if u, ok := v.(*unsetValue); ok {
+			return u
+		}
+		w, ok := v.(interface {
+			unwrap() flag.Value
+		})
+		if !ok {
+			return nil
+		}
+		v = w.unwrap()
+	}
+}
+
+// isBoolFlag tells whether the value is a boolean one, which can be set
+// without a value. Wrappers have to forward it to the flag package.
+func isBoolFlag(v flag.Value) bool {
+	b, ok := v.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// baseValue returns the flag value without the wrappers added by the maker.
+func baseValue(v flag.Value) flag.Value {
+	if w, ok := v.(interface {
+		unwrap() flag.Value
+	}); ok {
+		return baseValue(w.unwrap())
+	}
+	return v
+}
+
+// copyValue returns a copy of v which isn't affected by later modifications
+// of v, i.e. slices don't share their underlying array, maps are copied, and
+// fractions, which are set in place, are copied.
+func copyValue(v reflect.Value) reflect.Value {
+	c := reflect.New(v.Type()).Elem()
+	switch {
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		c.Set(reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v))
+	case v.Kind() == reflect.Map && !v.IsNil():
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+		c.Set(m)
+	case v.Type() == ratPtrType && !v.IsNil():
+		c.Set(reflect.ValueOf(new(big.Rat).Set(v.Interface().(*big.Rat))))
+	default:
+		c.Set(v)
+	}
+	return c
+}
+
+// deepCopy returns a copy of v sharing no pointers, slices or maps with it.
+// Unexported fields are copied as is.
+func deepCopy(v reflect.Value) reflect.Value {
+	c := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.Type() == ratPtrType {
+			return copyValue(v)
+		}
+		if !v.IsNil() {
+			p := reflect.New(v.Type().Elem())
+			p.Elem().Set(deepCopy(v.Elem()))
+			c.Set(p)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			c.Set(deepCopy(v.Elem()))
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			c.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				c.Index(i).Set(deepCopy(v.Index(i)))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			c.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+			iter := v.MapRange()
+			for iter.Next() {
+				c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+			}
+		}
+	case reflect.Struct:
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+	default:
+		c.Set(v)
+	}
+	return c
+}
+
+// check turns a check into a step of a checkedValue leaving the value as is.
+func check(fn func(string) error) func(string) (string, error) {
+	return func(str string) (string, error) {
+		return str, fn(str)
+	}
+}
+
+// oneOf returns a check accepting only the given values.
+func oneOf(allowed []string) func(string) error {
+	return func(str string) error {
+		for _, a := range allowed {
+			if str == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", str, strings.Join(allowed, ", "))
+	}
+}
+
+// maxBytes returns a check rejecting the values longer than limit bytes.
+func maxBytes(limit int) func(string) error {
+	return func(str string) error {
+		if len(str) > limit {
+			return fmt.Errorf("value too long (max %d bytes)", limit)
+		}
+		return nil
+	}
+}
+
+// coerceInt is a step converting the integral floats, e.g. 3.0, given to an
+// int flag to ints, and rejecting the others, e.g. 3.5, which would lose
+// their fraction.
+func coerceInt(str string) (string, error) {
+	if _, err := strconv.Atoi(str); err == nil {
+		return str, nil
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		// invalid numbers are rejected by the flag value
+		return str, nil
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return str, fmt.Errorf("%s cannot be converted to an int without loss", str)
+	}
+	return strconv.FormatInt(int64(f), 10), nil
+}
+
+// cleanPath returns a step cleaning the paths with filepath.Clean, or making
+// them absolute with filepath.Abs, which cleans them as well. Empty values,
+// which both would turn into the working directory, are kept.
+func cleanPath(abs bool) func(string) (string, error) {
+	return func(str string) (string, error) {
+		if len(str) == 0 {
+			return str, nil
+		}
+		if abs {
+			return filepath.Abs(str)
+		}
+		return filepath.Clean(str), nil
+	}
+}
+
+// resolvable returns a check rejecting the hosts the resolver cannot look up
+// within the timeout, or DefaultLookupTimeout if it isn't positive.
+func resolvable(resolver HostResolver, timeout time.Duration) func(string) error {
+	if timeout <= 0 {
+		timeout = DefaultLookupTimeout
+	}
+	return func(str string) error {
+		host := str
+		if h, _, err := net.SplitHostPort(str); err == nil {
+			host = h
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if _, err := resolver.LookupHost(ctx, host); err != nil {
+			return fmt.Errorf("cannot resolve %q: %v", host, err)
+		}
+		return nil
+	}
+}
+
+// mustExist returns a check rejecting the paths stat cannot find, or which
+// aren't a directory for the dir mode or are one for the file mode.
+func mustExist(stat func(string) (os.FileInfo, error), mode string) func(string) error {
+	return func(str string) error {
+		info, err := stat(str)
+		switch {
+		case err != nil:
+			return err
+		case mode == "dir" && !info.IsDir():
+			return fmt.Errorf("%s is not a directory", str)
+		case mode == "file" && info.IsDir():
+			return fmt.Errorf("%s is a directory", str)
+		}
+		return nil
+	}
+}
+
+// confirmToken returns a step enabling a bool flag only with the given token,
+// e.g. --allowdataloss=I-UNDERSTAND, rather than true. It can still be
+// disabled.
+func confirmToken(name, token string) func(string) (string, error) {
+	return func(str string) (string, error) {
+		if str == token {
+			return "true", nil
+		}
+		if b, err := strconv.ParseBool(str); err == nil && !b {
+			return str, nil
+		}
+		return str, fmt.Errorf("flag %s must be set to %s to be enabled", name, token)
+	}
+}